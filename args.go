@@ -0,0 +1,234 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// testValueFlags are the go test and go build flags that take a separate
+// value argument, they are needed to tell flag values and package patterns
+// apart.
+var testValueFlags = []string{
+	"C", "asmflags", "bench", "benchtime", "blockprofile", "blockprofilerate",
+	"count", "coverpkg", "covermode", "coverprofile", "cpu", "cpuprofile",
+	"exec", "fullpath", "fuzz", "fuzzcachedir", "fuzzminimizetime",
+	"fuzztime", "gccgoflags", "gcflags", "installsuffix", "ldflags", "list",
+	"memprofile", "memprofilerate", "mod", "modfile", "mutexprofile",
+	"mutexprofilefraction", "o", "outputdir", "overlay", "p", "parallel",
+	"pgo", "pkgdir", "run", "shuffle", "skip", "tags", "timeout",
+	"toolexec", "trace", "vet",
+}
+
+// goTestFlags are the flags only go test itself understands, they are
+// neither build flags nor passed on to the test binary.
+var goTestFlags = []string{"c", "exec", "json", "o", "vet"}
+
+// testBinaryFlags are the go test flags that go test passes on to the test
+// binary, every flag not in it or goTestFlags is a build flag.
+var testBinaryFlags = []string{
+	"bench", "benchmem", "benchtime", "blockprofile", "blockprofilerate",
+	"count", "coverprofile", "cpu", "cpuprofile", "failfast", "fullpath",
+	"fuzz", "fuzzcachedir", "fuzzminimizetime", "fuzztime", "list",
+	"memprofile", "memprofilerate", "mutexprofile", "mutexprofilefraction",
+	"outputdir", "parallel", "run", "short", "shuffle", "skip", "timeout",
+	"trace", "v",
+}
+
+// TestArgs is the arguments to go test split into flags, package patterns and
+// the arguments after -args which are passed to the test binary.
+type TestArgs struct {
+	Flags    []string
+	Packages []string
+	Args     []string
+}
+
+// ParseTestArgs splits go test arguments.
+func ParseTestArgs(argv []string) TestArgs {
+	var ta TestArgs
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "-args" || arg == "--args" {
+			ta.Args = append(ta.Args, argv[i:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			ta.Packages = append(ta.Packages, arg)
+			continue
+		}
+		ta.Flags = append(ta.Flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		name = strings.TrimPrefix(name, "test.")
+		if slices.Contains(testValueFlags, name) && i+1 < len(argv) {
+			i++
+			ta.Flags = append(ta.Flags, argv[i])
+		}
+	}
+	return ta
+}
+
+// Argv joins the arguments back together.
+func (ta TestArgs) Argv() []string {
+	var argv []string
+	argv = append(argv, ta.Flags...)
+	argv = append(argv, ta.Packages...)
+	argv = append(argv, ta.Args...)
+	return argv
+}
+
+// WithPackages returns a copy with the package patterns replaced.
+func (ta TestArgs) WithPackages(packages ...string) TestArgs {
+	ta.Packages = packages
+	return ta
+}
+
+// Without returns a copy with every occurrence of the named flags and their
+// values removed.
+func (ta TestArgs) Without(names ...string) TestArgs {
+	var flags []string
+	for i := 0; i < len(ta.Flags); i++ {
+		arg := ta.Flags[i]
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		name = strings.TrimPrefix(name, "test.")
+		if !strings.HasPrefix(arg, "-") || !slices.Contains(names, name) {
+			flags = append(flags, arg)
+			continue
+		}
+		if !hasValue && slices.Contains(testValueFlags, name) && i+1 < len(ta.Flags) {
+			i++
+		}
+	}
+	ta.Flags = flags
+	return ta
+}
+
+// Patterns returns the package patterns or the default go test uses when
+// none are given.
+func (ta TestArgs) Patterns() []string {
+	if len(ta.Packages) == 0 {
+		return []string{"."}
+	}
+	return ta.Packages
+}
+
+// Lookup returns the value of the last occurrence of a flag.
+func (ta TestArgs) Lookup(name string) (value string, ok bool) {
+	for i := 0; i < len(ta.Flags); i++ {
+		arg := ta.Flags[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		n, v, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		n = strings.TrimPrefix(n, "test.")
+		if n != name {
+			continue
+		}
+		switch {
+		case hasValue:
+			value = v
+		case slices.Contains(testValueFlags, n) && i+1 < len(ta.Flags):
+			i++
+			value = ta.Flags[i]
+		default:
+			value = "true"
+		}
+		ok = true
+	}
+	return value, ok
+}
+
+// reproFlags are the flags that change how tests behave and are kept in
+// reproduction commands.
+var reproFlags = []string{
+	"asan", "asmflags", "count", "cover", "covermode", "coverpkg", "cpu",
+	"failfast", "gcflags", "ldflags", "mod", "modfile", "msan", "overlay",
+	"parallel", "pgo", "race", "run", "short", "shuffle", "skip", "tags",
+	"timeout",
+}
+
+// ReproFlags returns the flags in reproFlags with flags named in override
+// removed, values are always joined with '=' to the flag name.
+func (ta TestArgs) ReproFlags(override ...string) []string {
+	var flags []string
+	for i := 0; i < len(ta.Flags); i++ {
+		arg := ta.Flags[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		name = strings.TrimPrefix(name, "test.")
+		if !hasValue && slices.Contains(testValueFlags, name) && i+1 < len(ta.Flags) {
+			i++
+			value, hasValue = ta.Flags[i], true
+		}
+		if !slices.Contains(reproFlags, name) || slices.Contains(override, name) {
+			continue
+		}
+		if hasValue {
+			flags = append(flags, "-"+name+"="+value)
+		} else {
+			flags = append(flags, "-"+name)
+		}
+	}
+	return flags
+}
+
+// SplitFlags splits the flags into build flags that go list and go build
+// take, flags only go test takes and flags for the test binary. Values are
+// joined with '=' to the flag name and binary flags get the test. prefix.
+func (ta TestArgs) SplitFlags() (build, goTest, binary []string) {
+	for i := 0; i < len(ta.Flags); i++ {
+		arg := ta.Flags[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		name = strings.TrimPrefix(name, "test.")
+		if !hasValue && slices.Contains(testValueFlags, name) && i+1 < len(ta.Flags) {
+			i++
+			value, hasValue = ta.Flags[i], true
+		}
+		flag := "-" + name
+		if hasValue {
+			flag += "=" + value
+		}
+		switch {
+		case slices.Contains(testBinaryFlags, name):
+			binary = append(binary, "-test."+strings.TrimPrefix(flag, "-"))
+		case slices.Contains(goTestFlags, name):
+			goTest = append(goTest, flag)
+		default:
+			build = append(build, flag)
+		}
+	}
+	return build, goTest, binary
+}
+
+// shellQuote quotes s for a POSIX shell if it contains anything but safe
+// characters.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			strings.ContainsRune("-_=./:,+@%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// RunPattern returns a -run pattern that matches exactly one test or subtest.
+func RunPattern(test string) string {
+	elems := strings.Split(test, "/")
+	for i, elem := range elems {
+		elems[i] = "^" + regexp.QuoteMeta(elem) + "$"
+	}
+	return strings.Join(elems, "/")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Attr is a test attribute set with t.Attr.
+type Attr struct {
+	Key   string
+	Value string
+}
+
+// Attrs are the attributes of a test in the order they were set.
+type Attrs []Attr
+
+func (as Attrs) String() string {
+	var parts []string
+	for _, a := range as {
+		parts = append(parts, a.Key+"="+a.Value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Get returns the last value set for key.
+func (as Attrs) Get(key string) (string, bool) {
+	for i := len(as) - 1; i >= 0; i-- {
+		if as[i].Key == key {
+			return as[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Map returns the attributes as a map, later values win.
+func (as Attrs) Map() map[string]string {
+	if len(as) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(as))
+	for _, a := range as {
+		m[a.Key] = a.Value
+	}
+	return m
+}
+
+// Attrs returns the attributes reported by attr events.
+func (es Events) Attrs() Attrs {
+	var attrs Attrs
+	for _, e := range es {
+		if e.Action == ActionAttr {
+			attrs = append(attrs, Attr{Key: e.AttrKey, Value: e.AttrValue})
+		}
+	}
+	return attrs
+}
+
+// Attr returns the value of the attribute name of the test of key, subtests
+// inherit the attributes of their parents.
+func (ts TestStorage) Attr(key Key, name string) (string, bool) {
+	for key.Test != "" {
+		if v, ok := ts[key].Attrs().Get(name); ok {
+			return v, true
+		}
+		i := strings.LastIndex(key.Test, "/")
+		if i < 0 {
+			break
+		}
+		key.Test = key.Test[:i]
+	}
+	return "", false
+}
+
+// FilterAttr returns the tests whose attribute name has value, attributes are
+// looked up in all.
+func (ts TestStorage) FilterAttr(all TestStorage, name, value string) TestStorage {
+	tests := make(TestStorage, 0)
+	for key, events := range ts {
+		if v, ok := all.Attr(key, name); ok && v == value {
+			tests[key] = events
+		}
+	}
+	return tests
+}
+
+// AttrFilter selects the tests whose attribute Name has Value, it is set from
+// name=value.
+type AttrFilter struct {
+	Name  string
+	Value string
+}
+
+func (af *AttrFilter) String() string {
+	if af.Name == "" {
+		return ""
+	}
+	return af.Name + "=" + af.Value
+}
+
+func (af *AttrFilter) Set(value string) error {
+	if value == "" {
+		*af = AttrFilter{}
+		return nil
+	}
+	name, v, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("%s is not a valid attribute filter, expected name=value", value)
+	}
+	*af = AttrFilter{Name: name, Value: v}
+	return nil
+}
+
+// Filter returns the tests in ts that pass the filter, attributes are looked
+// up in all. Without a filter ts is returned as is.
+func (af AttrFilter) Filter(ts, all TestStorage) TestStorage {
+	if af.Name == "" {
+		return ts
+	}
+	return ts.FilterAttr(all, af.Name, af.Value)
+}
+
+// GroupBy is how summaries are grouped, it is set from attr:NAME to group by
+// the value of the attribute NAME.
+type GroupBy struct {
+	Attr string
+}
+
+func (g *GroupBy) String() string {
+	if g.Attr == "" {
+		return ""
+	}
+	return "attr:" + g.Attr
+}
+
+func (g *GroupBy) Set(value string) error {
+	if value == "" {
+		*g = GroupBy{}
+		return nil
+	}
+	name, ok := strings.CutPrefix(value, "attr:")
+	if !ok || name == "" {
+		return fmt.Errorf("%s is not a valid grouping, expected attr:NAME", value)
+	}
+	*g = GroupBy{Attr: name}
+	return nil
+}
+
+// AttrGroup is the results that share the value of an attribute.
+type AttrGroup struct {
+	Value   string
+	Missing bool // the results do not have the attribute
+	Keys    []Key
+}
+
+// GroupByAttr groups the results by the value of the attribute name looked
+// up in all. Groups are ordered by value with results without the attribute
+// last.
+func (ts TestStorage) GroupByAttr(all TestStorage, name string) []AttrGroup {
+	var (
+		groups []AttrGroup
+		index  = make(map[string]int)
+		none   []Key
+	)
+	for _, key := range ts.OrderedKeys() {
+		v, ok := all.Attr(key, name)
+		if !ok {
+			none = append(none, key)
+			continue
+		}
+		i, found := index[v]
+		if !found {
+			i = len(groups)
+			index[v] = i
+			groups = append(groups, AttrGroup{Value: v})
+		}
+		groups[i].Keys = append(groups[i].Keys, key)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Value < groups[j].Value
+	})
+	if len(none) > 0 {
+		groups = append(groups, AttrGroup{Missing: true, Keys: none})
+	}
+	return groups
+}
+
+// printSummary prints the summary of status for the results in filtered with
+// the attribute filter and grouping of flags applied.
+func (ts TestStorage) printSummary(flags Flags, filtered TestStorage, status Status) {
+	filtered = flags.Attr.Filter(filtered, ts)
+	if len(filtered) == 0 {
+		return
+	}
+	name := flags.GroupBy.Attr
+	if name == "" {
+		filtered.PrintSummary(status)
+		return
+	}
+	printSummaryHeader(status)
+	for _, g := range filtered.GroupByAttr(ts, name) {
+		label := fmt.Sprintf("%s=%s", name, g.Value)
+		if g.Missing {
+			label = "no " + name
+		}
+		fmt.Println(attrColor("  ── " + label))
+		filtered.printSummaryRows(status, g.Keys)
+	}
+}
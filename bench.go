@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// BenchValue is one measurement of a benchmark result line.
+type BenchValue struct {
+	Value float64
+	Unit  string
+}
+
+// BenchResult is one benchmark result line like
+// "BenchmarkX-8  1000  1234 ns/op  56 B/op  2 allocs/op".
+type BenchResult struct {
+	Name       string
+	Iterations int
+	Values     []BenchValue
+}
+
+// ParseBenchLine parses a benchmark result line, custom b.ReportMetric units
+// are kept as they are.
+func ParseBenchLine(line string) (BenchResult, bool) {
+	var r BenchResult
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return r, false
+	}
+	n, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return r, false
+	}
+	r.Name, r.Iterations = fields[0], n
+	for i := 2; i+1 < len(fields); i += 2 {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return r, false
+		}
+		r.Values = append(r.Values, BenchValue{Value: v, Unit: fields[i+1]})
+	}
+	return r, true
+}
+
+// BenchMetric is every sample of one unit of a benchmark.
+type BenchMetric struct {
+	Unit   string    `json:"unit"`
+	Values []float64 `json:"values"`
+	Median float64   `json:"median"`
+	Mean   float64   `json:"mean"`
+	StdDev float64   `json:"stddev"`
+}
+
+// Benchmark is the results of a benchmark over all -count repeats.
+type Benchmark struct {
+	Module  string        `json:"module,omitempty"`
+	Package string        `json:"package"`
+	Variant string        `json:"variant,omitempty"`
+	Name    string        `json:"name"`
+	Samples int           `json:"samples"`
+	Metrics []BenchMetric `json:"metrics"`
+}
+
+// Metric returns the metric with unit.
+func (b Benchmark) Metric(unit string) (BenchMetric, bool) {
+	for _, m := range b.Metrics {
+		if m.Unit == unit {
+			return m, true
+		}
+	}
+	return BenchMetric{}, false
+}
+
+// add adds the values of one result line.
+func (b *Benchmark) add(r BenchResult) {
+	b.Samples++
+	for _, v := range r.Values {
+		i := slices.IndexFunc(b.Metrics, func(m BenchMetric) bool { return m.Unit == v.Unit })
+		if i < 0 {
+			i = len(b.Metrics)
+			b.Metrics = append(b.Metrics, BenchMetric{Unit: v.Unit})
+		}
+		b.Metrics[i].Values = append(b.Metrics[i].Values, v.Value)
+	}
+}
+
+// stats computes the median, mean and standard deviation of the values.
+func (m *BenchMetric) stats() {
+	if len(m.Values) == 0 {
+		return
+	}
+	sorted := slices.Clone(m.Values)
+	slices.Sort(sorted)
+	if n := len(sorted); n%2 == 1 {
+		m.Median = sorted[n/2]
+	} else {
+		m.Median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	m.Mean = sum / float64(len(sorted))
+	if len(sorted) > 1 {
+		var sq float64
+		for _, v := range sorted {
+			sq += (v - m.Mean) * (v - m.Mean)
+		}
+		m.StdDev = math.Sqrt(sq / float64(len(sorted)-1))
+	}
+}
+
+// Benchmarks parses the benchmark results in the output of every package in
+// the order they were first reported.
+func (ts TestStorage) Benchmarks() []Benchmark {
+	var benchmarks []Benchmark
+	output := ts.OutputByPackage()
+	for _, key := range ts.FindPackageResults().OrderedKeys() {
+		index := make(map[string]int)
+		for _, line := range output[key] {
+			r, ok := ParseBenchLine(line)
+			if !ok {
+				continue
+			}
+			i, ok := index[r.Name]
+			if !ok {
+				i = len(benchmarks)
+				index[r.Name] = i
+				benchmarks = append(benchmarks, Benchmark{
+					Module:  key.Module,
+					Package: key.Package,
+					Variant: key.Variant,
+					Name:    r.Name,
+				})
+			}
+			benchmarks[i].add(r)
+		}
+	}
+	for i := range benchmarks {
+		for j := range benchmarks[i].Metrics {
+			benchmarks[i].Metrics[j].stats()
+		}
+	}
+	return benchmarks
+}
+
+// unitLabels are the column headers of the standard units.
+var unitLabels = map[string]string{
+	"ns/op":     "time/op",
+	"B/op":      "mem/op",
+	"allocs/op": "allocs/op",
+	"MB/s":      "speed",
+}
+
+// FormatBenchValue formats a value of unit with a human friendly scale.
+func FormatBenchValue(v float64, unit string) string {
+	switch unit {
+	case "ns/op":
+		switch {
+		case v >= 1e9:
+			return fmt.Sprintf("%.3gs", v/1e9)
+		case v >= 1e6:
+			return fmt.Sprintf("%.3gms", v/1e6)
+		case v >= 1e3:
+			return fmt.Sprintf("%.3gµs", v/1e3)
+		default:
+			return fmt.Sprintf("%.3gns", v)
+		}
+	case "B/op":
+		switch {
+		case v >= 1<<30:
+			return fmt.Sprintf("%.3gGiB", v/(1<<30))
+		case v >= 1<<20:
+			return fmt.Sprintf("%.3gMiB", v/(1<<20))
+		case v >= 1<<10:
+			return fmt.Sprintf("%.3gKiB", v/(1<<10))
+		default:
+			return fmt.Sprintf("%.0fB", v)
+		}
+	case "allocs/op":
+		return fmt.Sprintf("%.0f", v)
+	case "MB/s":
+		return fmt.Sprintf("%.4gMB/s", v)
+	default:
+		return fmt.Sprintf("%.4g", v)
+	}
+}
+
+// Cell returns the median of the metric and its spread when there are
+// several samples.
+func (m BenchMetric) Cell() string {
+	s := FormatBenchValue(m.Median, m.Unit)
+	if len(m.Values) > 1 && m.Mean != 0 {
+		s += fmt.Sprintf(" ± %.0f%%", 100*m.StdDev/math.Abs(m.Mean))
+	}
+	return s
+}
+
+// PrintBenchmarks prints an aligned table of the benchmarks of every package.
+func (ts TestStorage) PrintBenchmarks() {
+	benchmarks := ts.Benchmarks()
+	if len(benchmarks) == 0 {
+		return
+	}
+	hr := passColor("════════════")
+	fmt.Println(hr, passColorBold("BENCHMARKS"), hr)
+	for start := 0; start < len(benchmarks); {
+		end := start + 1
+		for end < len(benchmarks) && benchmarks[end].Package == benchmarks[start].Package &&
+			benchmarks[end].Module == benchmarks[start].Module && benchmarks[end].Variant == benchmarks[start].Variant {
+			end++
+		}
+		printBenchTable(benchmarks[start:end])
+		start = end
+	}
+}
+
+// printBenchTable prints the benchmarks of one package.
+func printBenchTable(benchmarks []Benchmark) {
+	b := benchmarks[0]
+	header := "  " + packageColor(b.Package)
+	if b.Variant != "" {
+		header += "  " + variantColor("["+b.Variant+"]")
+	}
+	fmt.Println(header)
+
+	var units []string
+	samples := false
+	for _, b := range benchmarks {
+		samples = samples || b.Samples > 1
+		for _, m := range b.Metrics {
+			if !slices.Contains(units, m.Unit) {
+				units = append(units, m.Unit)
+			}
+		}
+	}
+	rows := [][]string{{"name"}}
+	if samples {
+		rows[0] = append(rows[0], "n")
+	}
+	for _, u := range units {
+		label, ok := unitLabels[u]
+		if !ok {
+			label = u
+		}
+		rows[0] = append(rows[0], label)
+	}
+	for _, b := range benchmarks {
+		row := []string{strings.TrimPrefix(b.Name, "Benchmark")}
+		if samples {
+			row = append(row, strconv.Itoa(b.Samples))
+		}
+		for _, u := range units {
+			var cell string
+			if m, ok := b.Metric(u); ok {
+				cell = m.Cell()
+			}
+			row = append(row, cell)
+		}
+		rows = append(rows, row)
+	}
+
+	printAligned(rows, func(r, c int, cell string) string {
+		switch {
+		case r == 0:
+			return hardLineColor(cell)
+		case c == 0:
+			return testColor(cell)
+		}
+		return cell
+	})
+}
+
+// printAligned prints rows as a table with the first column left aligned and
+// the others right aligned, colorize is applied to each cell before padding.
+func printAligned(rows [][]string, colorize func(r, c int, cell string) string) {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], len([]rune(cell)))
+		}
+	}
+	for r, row := range rows {
+		var sb strings.Builder
+		sb.WriteString("    ")
+		for i, cell := range row {
+			pad := strings.Repeat(" ", widths[i]-len([]rune(cell)))
+			cell = colorize(r, i, cell)
+			if i == 0 {
+				sb.WriteString(cell + pad)
+			} else {
+				sb.WriteString("  " + pad + cell)
+			}
+		}
+		fmt.Println(strings.TrimRight(sb.String(), " "))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultBenchCount is the number of samples bench-compare takes of every
+// benchmark when -count is not given.
+const defaultBenchCount = 6
+
+// benchCompareArgs returns the go test arguments of one bench-compare round
+// and the number of rounds. Every round runs each benchmark once, tests are
+// not run unless -run is given.
+func benchCompareArgs(argv []string) ([]string, int, error) {
+	ta := ParseTestArgs(argv)
+	count := defaultBenchCount
+	if v, ok := ta.Lookup("count"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, 0, fmt.Errorf("invalid -count %q", v)
+		}
+		count = n
+	}
+	ta = ta.Without("count")
+	if _, ok := ta.Lookup("bench"); !ok {
+		ta.Flags = append(ta.Flags, "-bench=.")
+	}
+	if _, ok := ta.Lookup("run"); !ok {
+		ta.Flags = append(ta.Flags, "-run=^$")
+	}
+	ta.Flags = append(ta.Flags, "-count=1")
+	return ta.Argv(), count, nil
+}
+
+// benchCompare runs the benchmarks of ref, checked out in a temporary git
+// worktree, and of the working tree in alternating rounds and prints how
+// they differ.
+func benchCompare(ctx context.Context, flags Flags, argv []string) error {
+	if len(argv) == 0 || strings.HasPrefix(argv[0], "-") {
+		return errors.New("usage: tgo bench-compare <ref> [go test flags] [packages]")
+	}
+	ref := argv[0]
+	args, count, err := benchCompareArgs(argv[1:])
+	if err != nil {
+		return err
+	}
+
+	rev, err := git(ctx, "rev-parse", "--short", ref+"^{commit}")
+	if err != nil {
+		return err
+	}
+	// the working directory relative to the top of the repository, empty at
+	// the top
+	prefix, err := git(ctx, "rev-parse", "--show-prefix")
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "tgo-bench-compare-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := git(ctx, "worktree", "add", "--detach", dir, rev[0]); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := git(context.Background(), "worktree", "remove", "--force", dir); err != nil {
+			fmt.Println(err)
+		}
+	}()
+
+	sides := []struct {
+		label string
+		gt    *GoTest
+		tests TestStorage
+	}{
+		{label: ref, gt: &GoTest{Dir: filepath.Join(append([]string{dir}, prefix...)...), Args: args}, tests: make(TestStorage)},
+		{label: "working tree", gt: &GoTest{Args: args}, tests: make(TestStorage)},
+	}
+	fmt.Println("***** bench-compare", ref, "("+rev[0]+") vs working tree,", count, "rounds of", sides[1].gt.String())
+	for round := range count {
+		// alternate which side runs first so slow drift of the machine
+		// affects both the same
+		for i := range sides {
+			side := sides[(round+i)%len(sides)]
+			fmt.Printf("\rround %d/%d: %s\033[K", round+1, count, side.label)
+			sr := stressOnce(ctx, flags.Bin, []*GoTest{side.gt})
+			if ctx.Err() != nil {
+				fmt.Println()
+				return ctx.Err()
+			}
+			if sr.Failed() {
+				fmt.Println()
+				failed := sr.tests.FindByAction(ActionFail).Union(sr.tests.FindByAction(ActionBuildFail))
+				for _, key := range failed.OrderedKeys() {
+					sr.tests[key].PrintDetail(flags)
+				}
+				if sr.err != nil {
+					return fmt.Errorf("%s: %w", side.label, sr.err)
+				}
+				return fmt.Errorf("%s: benchmarks failed", side.label)
+			}
+			for _, e := range sr.events {
+				side.tests.Append(e)
+			}
+		}
+	}
+	fmt.Println()
+
+	cs := CompareBenchmarks(sides[0].tests.Benchmarks(), sides[1].tests.Benchmarks())
+	if len(cs) == 0 {
+		fmt.Println("no benchmarks found in both", ref, "and the working tree")
+		return nil
+	}
+	regressions := PrintBenchComparison(cs, ref, "working tree", flags.BenchThreshold)
+	if flags.BenchThreshold > 0 && regressions > 0 {
+		return ExitError(1)
+	}
+	return nil
+}
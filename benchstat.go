@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+)
+
+// benchAlpha is the significance level of benchmark comparisons.
+const benchAlpha = 0.05
+
+// benchConfidence is the confidence level of the interval around medians.
+const benchConfidence = 0.95
+
+// LoadBenchBaseline reads the benchmarks of a file written by -json-summary.
+func LoadBenchBaseline(path string) ([]Benchmark, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s JSONSummary
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(s.Benchmarks) == 0 {
+		return nil, fmt.Errorf("%s: no benchmark results", path)
+	}
+	return s.Benchmarks, nil
+}
+
+// rank returns the ranks of the values of x and y in their union, tied values
+// get the mean of their ranks. It also returns the tie correction term, the
+// sum of t³-t over every group of t tied values.
+func rank(x, y []float64) (rx, ry []float64, ties float64) {
+	type value struct {
+		v float64
+		i int // index in x, or in y offset by len(x)
+	}
+	all := make([]value, 0, len(x)+len(y))
+	for i, v := range x {
+		all = append(all, value{v, i})
+	}
+	for i, v := range y {
+		all = append(all, value{v, len(x) + i})
+	}
+	slices.SortFunc(all, func(a, b value) int {
+		switch {
+		case a.v < b.v:
+			return -1
+		case a.v > b.v:
+			return 1
+		}
+		return 0
+	})
+	rx, ry = make([]float64, len(x)), make([]float64, len(y))
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		r := float64(i+j+1) / 2
+		if t := float64(j - i); t > 1 {
+			ties += t*t*t - t
+		}
+		for _, a := range all[i:j] {
+			if a.i < len(x) {
+				rx[a.i] = r
+			} else {
+				ry[a.i-len(x)] = r
+			}
+		}
+		i = j
+	}
+	return rx, ry, ties
+}
+
+// maxExactU is the largest sample size for which MannWhitneyU computes the
+// exact distribution of U.
+const maxExactU = 25
+
+// uCounts returns how many orderings of m and n values give each U from 0 to
+// m*n.
+func uCounts(m, n int) []float64 {
+	// counts[i][j][u] is the number of orderings of i and j values where u
+	// pairs have the value from the first sample larger.
+	counts := make([][][]float64, m+1)
+	for i := range counts {
+		counts[i] = make([][]float64, n+1)
+		for j := range counts[i] {
+			c := make([]float64, i*j+1)
+			switch {
+			case i == 0 || j == 0:
+				c[0] = 1
+			default:
+				for u := range c {
+					if u-j >= 0 && u-j < len(counts[i-1][j]) {
+						c[u] += counts[i-1][j][u-j]
+					}
+					if u < len(counts[i][j-1]) {
+						c[u] += counts[i][j-1][u]
+					}
+				}
+			}
+			counts[i][j] = c
+		}
+	}
+	return counts[m][n]
+}
+
+// MannWhitneyU returns the U statistic of x and the two sided p-value of the
+// Mann-Whitney U test that x and y come from the same distribution. The
+// p-value is exact for small samples without ties and uses the normal
+// approximation with tie correction otherwise.
+func MannWhitneyU(x, y []float64) (u, p float64) {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+	rx, _, ties := rank(x, y)
+	var r1 float64
+	for _, r := range rx {
+		r1 += r
+	}
+	u = r1 - float64(n1*(n1+1))/2
+
+	if ties == 0 && n1 <= maxExactU && n2 <= maxExactU {
+		counts := uCounts(n1, n2)
+		var total, below, above float64
+		for k, c := range counts {
+			total += c
+			if float64(k) <= u {
+				below += c
+			}
+			if float64(k) >= u {
+				above += c
+			}
+		}
+		return u, math.Min(1, 2*math.Min(below, above)/total)
+	}
+
+	n := float64(n1 + n2)
+	mu := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	z := math.Max(0, math.Abs(u-mu)-0.5) / sigma
+	return u, math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// binomCDF returns P(X <= k) for X ~ Binomial(n, 1/2).
+func binomCDF(n, k int) float64 {
+	var sum, c float64 = 0, 1
+	for i := 0; i <= k; i++ {
+		sum += c
+		c = c * float64(n-i) / float64(i+1)
+	}
+	return sum / math.Pow(2, float64(n))
+}
+
+// MedianCI returns the distribution free confidence interval of the median of
+// values from their order statistics, ok is false when there are too few
+// values for the confidence level.
+func MedianCI(values []float64, confidence float64) (lo, hi float64, ok bool) {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	k := 0
+	for k < n/2 && binomCDF(n, k) <= (1-confidence)/2 {
+		k++
+	}
+	if k == 0 {
+		return 0, 0, false
+	}
+	return sorted[k-1], sorted[n-k], true
+}
+
+// higherIsBetter reports whether larger values of unit are improvements.
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+// BenchComparison is a metric of a benchmark in a baseline and in this run.
+type BenchComparison struct {
+	Module  string
+	Package string
+	Variant string
+	Name    string
+	Unit    string
+	Old     BenchMetric
+	New     BenchMetric
+	Delta   float64 // change of the median in percent
+	P       float64
+}
+
+// Significant reports whether the difference is unlikely to be noise.
+func (c BenchComparison) Significant() bool {
+	return c.P < benchAlpha
+}
+
+// Regression reports whether the metric got significantly worse by more than
+// threshold percent.
+func (c BenchComparison) Regression(threshold float64) bool {
+	worse := c.Delta > 0
+	if higherIsBetter(c.Unit) {
+		worse = c.Delta < 0
+	}
+	return c.Significant() && worse && math.Abs(c.Delta) > threshold
+}
+
+// CompareBenchmarks compares every metric of the benchmarks in cur with the
+// same benchmark in base, benchmarks missing from either are left out.
+func CompareBenchmarks(base, cur []Benchmark) []BenchComparison {
+	var cs []BenchComparison
+	for _, b := range cur {
+		i := slices.IndexFunc(base, func(o Benchmark) bool {
+			return o.Module == b.Module && o.Package == b.Package && o.Variant == b.Variant && o.Name == b.Name
+		})
+		if i < 0 {
+			continue
+		}
+		for _, m := range b.Metrics {
+			old, ok := base[i].Metric(m.Unit)
+			if !ok {
+				continue
+			}
+			c := BenchComparison{
+				Module:  b.Module,
+				Package: b.Package,
+				Variant: b.Variant,
+				Name:    b.Name,
+				Unit:    m.Unit,
+				Old:     old,
+				New:     m,
+			}
+			if old.Median != 0 {
+				c.Delta = 100 * (m.Median - old.Median) / math.Abs(old.Median)
+			}
+			_, c.P = MannWhitneyU(old.Values, m.Values)
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// ciCell returns the median of m with its confidence interval.
+func ciCell(m BenchMetric) string {
+	s := FormatBenchValue(m.Median, m.Unit)
+	lo, hi, ok := MedianCI(m.Values, benchConfidence)
+	switch {
+	case !ok:
+		s += " ± ∞"
+	case m.Median != 0:
+		s += fmt.Sprintf(" ± %.0f%%", 100*max(hi-m.Median, m.Median-lo)/math.Abs(m.Median))
+	}
+	return s
+}
+
+// deltaCell returns the change of c with its p-value, "~" when the change is
+// not significant.
+func (c BenchComparison) deltaCell() string {
+	delta := "~"
+	if c.Significant() {
+		delta = fmt.Sprintf("%+.2f%%", c.Delta)
+	}
+	return fmt.Sprintf("%s (p=%.3f n=%d+%d)", delta, c.P, len(c.Old.Values), len(c.New.Values))
+}
+
+// PrintBenchComparison prints a table per package and metric of the change
+// from oldLabel to newLabel and returns the number of regressions over
+// threshold percent.
+func PrintBenchComparison(cs []BenchComparison, oldLabel, newLabel string, threshold float64) int {
+	if len(cs) == 0 {
+		return 0
+	}
+	hr := passColor("════════════")
+	fmt.Println(hr, passColorBold("BENCHMARK COMPARISON"), hr)
+	var (
+		regressions int
+		noCI        bool
+	)
+	for start := 0; start < len(cs); {
+		end := start + 1
+		for end < len(cs) && cs[end].Package == cs[start].Package &&
+			cs[end].Module == cs[start].Module && cs[end].Variant == cs[start].Variant {
+			end++
+		}
+		header := "  " + packageColor(cs[start].Package)
+		if cs[start].Variant != "" {
+			header += "  " + variantColor("["+cs[start].Variant+"]")
+		}
+		fmt.Println(header)
+
+		var units []string
+		for _, c := range cs[start:end] {
+			if !slices.Contains(units, c.Unit) {
+				units = append(units, c.Unit)
+			}
+		}
+		var (
+			rows  [][]string
+			kinds []BenchComparison
+		)
+		for _, unit := range units {
+			label, ok := unitLabels[unit]
+			if !ok {
+				label = unit
+			}
+			rows = append(rows, []string{label, oldLabel, newLabel, "delta"})
+			kinds = append(kinds, BenchComparison{})
+			for _, c := range cs[start:end] {
+				if c.Unit != unit {
+					continue
+				}
+				rows = append(rows, []string{strings.TrimPrefix(c.Name, "Benchmark"), ciCell(c.Old), ciCell(c.New), c.deltaCell()})
+				kinds = append(kinds, c)
+				if c.Regression(threshold) {
+					regressions++
+				}
+				if _, _, ok := MedianCI(c.New.Values, benchConfidence); !ok {
+					noCI = true
+				}
+			}
+		}
+		printAligned(rows, func(r, col int, cell string) string {
+			c := kinds[r]
+			switch {
+			case c.Unit == "":
+				return hardLineColor(cell)
+			case col == 0:
+				return testColor(cell)
+			case col < 3 || !c.Significant():
+				return cell
+			case c.Regression(0):
+				return failColor(cell)
+			default:
+				return passColor(cell)
+			}
+		})
+		start = end
+	}
+	if noCI {
+		fmt.Println(noneColor(fmt.Sprintf("  ± ∞: need -count=6 or more for a %.0f%% confidence interval", 100*benchConfidence)))
+	}
+	if regressions > 0 && threshold > 0 {
+		fmt.Println(failColorBold(fmt.Sprintf("  %d significant regressions over %g%%", regressions, threshold)))
+	}
+	return regressions
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ListPackage is the subset of go list -json output used by tgo.
+type ListPackage struct {
+	Dir        string
+	ImportPath string
+	Name       string
+	ForTest    string
+	DepOnly    bool
+	Standard   bool
+	Deps       []string
+	Module     *struct {
+		Path  string
+		Dir   string
+		GoMod string
+	}
+}
+
+// BasePath returns the import path of the package the entry belongs to,
+// test variants like "p [p.test]" and test mains "p.test" map to "p".
+func (p ListPackage) BasePath() string {
+	if p.ForTest != "" {
+		return p.ForTest
+	}
+	path, _, _ := strings.Cut(p.ImportPath, " ")
+	if p.Name == "main" && strings.HasSuffix(path, ".test") {
+		return strings.TrimSuffix(path, ".test")
+	}
+	return path
+}
+
+// goList runs go list -json in dir and decodes the stream of packages.
+func goList(ctx context.Context, bin string, dir string, args ...string) ([]ListPackage, error) {
+	args = append([]string{"list", "-e", "-json"}, args...)
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", bin, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	var pkgs []ListPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p ListPackage
+		if err := dec.Decode(&p); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// git runs a git command and returns the non empty lines of its output.
+func git(ctx context.Context, args ...string) ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// ChangedFiles returns the absolute paths of the files that differ between
+// the merge base of ref and the working tree, including untracked files.
+func ChangedFiles(ctx context.Context, ref string) ([]string, error) {
+	top, err := git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	if len(top) == 0 {
+		return nil, errors.New("git rev-parse --show-toplevel: no output")
+	}
+	base := ref
+	if mb, err := git(ctx, "merge-base", ref, "HEAD"); err == nil && len(mb) > 0 {
+		base = mb[0]
+	}
+	changed, err := git(ctx, "diff", "--name-only", base, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(ctx, "ls-files", "--others", "--exclude-standard", "--full-name", top[0])
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range append(changed, untracked...) {
+		files = append(files, filepath.Join(top[0], filepath.FromSlash(name)))
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
+
+// SelectedPackage is a package chosen by -changed-since and why.
+type SelectedPackage struct {
+	ImportPath string
+	Reason     string
+}
+
+// SelectChangedPackages returns the packages matched by patterns whose own
+// files or whose dependencies, including test dependencies, contain any of
+// the changed files.
+func SelectChangedPackages(pkgs []ListPackage, files []string) []SelectedPackage {
+	dirs := make(map[string]string)
+	modRoots := make(map[string][]string)
+	for _, p := range pkgs {
+		if p.Standard || p.Dir == "" {
+			continue
+		}
+		dirs[p.Dir] = p.BasePath()
+		if p.Module != nil && p.Module.Dir != "" {
+			modRoots[p.Module.Dir] = append(modRoots[p.Module.Dir], p.BasePath())
+		}
+	}
+
+	changed := make(map[string][]string)
+	addChanged := func(pkg string, file string) {
+		if !slices.Contains(changed[pkg], file) {
+			changed[pkg] = append(changed[pkg], file)
+		}
+	}
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if before, _, ok := strings.Cut(dir, string(filepath.Separator)+"testdata"); ok {
+			dir = before
+		}
+		switch filepath.Base(file) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum":
+			for _, pkg := range modRoots[dir] {
+				addChanged(pkg, file)
+			}
+			continue
+		}
+		if pkg, ok := dirs[dir]; ok {
+			addChanged(pkg, filepath.Base(file))
+		}
+	}
+
+	deps := make(map[string][]string)
+	var targets []string
+	for _, p := range pkgs {
+		if p.Standard {
+			continue
+		}
+		base := p.BasePath()
+		if !p.DepOnly && !slices.Contains(targets, base) {
+			targets = append(targets, base)
+		}
+		for _, dep := range p.Deps {
+			dep, _, _ = strings.Cut(dep, " ")
+			if dep != base && !slices.Contains(deps[base], dep) {
+				deps[base] = append(deps[base], dep)
+			}
+		}
+	}
+	sort.Strings(targets)
+
+	var selected []SelectedPackage
+	for _, target := range targets {
+		if files, ok := changed[target]; ok {
+			selected = append(selected, SelectedPackage{
+				ImportPath: target,
+				Reason:     "changed " + strings.Join(files, ", "),
+			})
+			continue
+		}
+		var via []string
+		for _, dep := range deps[target] {
+			if _, ok := changed[dep]; ok {
+				via = append(via, dep)
+			}
+		}
+		if len(via) > 0 {
+			sort.Strings(via)
+			reason := "depends on " + via[0]
+			if len(via) > 1 {
+				reason += fmt.Sprintf(" and %d more", len(via)-1)
+			}
+			selected = append(selected, SelectedPackage{
+				ImportPath: target,
+				Reason:     reason,
+			})
+		}
+	}
+	return selected
+}
+
+// selectChanged narrows the package patterns in argv down to the packages
+// affected by changes since flags.ChangedSince.
+func selectChanged(ctx context.Context, flags Flags, argv []string) ([]string, bool, error) {
+	ta := ParseTestArgs(argv)
+	files, err := ChangedFiles(ctx, flags.ChangedSince)
+	if err != nil {
+		return nil, false, err
+	}
+	listArgs := []string{"-deps", "-test"}
+	if tags, ok := ta.Lookup("tags"); ok {
+		listArgs = append(listArgs, "-tags="+tags)
+	}
+	pkgs, err := goList(ctx, flags.Bin, "", append(listArgs, ta.Patterns()...)...)
+	if err != nil {
+		return nil, false, err
+	}
+	selected := SelectChangedPackages(pkgs, files)
+
+	hr := coverColor("════════════")
+	fmt.Println(hr, coverColor("CHANGED SINCE "+flags.ChangedSince), hr)
+	var packages []string
+	for _, s := range selected {
+		fmt.Println(packageColor(s.ImportPath) + "  " + timeColor(s.Reason))
+		packages = append(packages, s.ImportPath)
+	}
+	if len(selected) == 0 {
+		fmt.Printf("no packages affected by %d changed files\n", len(files))
+		return nil, false, nil
+	}
+	return ta.WithPackages(packages...).Argv(), true, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxClusterList is the number of tests listed per cluster unless the
+// verbosity is raised.
+const maxClusterList = 5
+
+// noisePatterns match the parts of output that change between otherwise
+// identical runs: timestamps, addresses, temporary paths, ports and
+// durations.
+var noisePatterns = []string{
+	`\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	`\b\d{2}:\d{2}:\d{2}(?:\.\d+)?\b`,
+	`0x[0-9a-fA-F]+`,
+	`(?:/tmp|/var/folders|` + regexp.QuoteMeta(strings.TrimSuffix(os.TempDir(), "/")) + `)/\S*`,
+	`(?:\b\d{1,3}(?:\.\d{1,3}){3}|\blocalhost|\[::1?\]):\d+`,
+	`\b\d+(?:\.\d+)?(?:ns|µs|us|ms|s|m|h)\b`,
+}
+
+// signatureRe matches the noise and every number in an error message, so
+// that tests failing for the same reason compare equal. IP addresses are
+// matched to be kept as they are.
+var signatureRe = regexp.MustCompile(strings.Join(append(slices.Clip(noisePatterns),
+	`\b\d{1,3}(?:\.\d{1,3}){3}`,
+	`\b\d+(?:\.\d+)?\b`,
+), "|"))
+
+// noisePlaceholder returns the placeholder for a match of outputNoiseRe or
+// signatureRe.
+func noisePlaceholder(s string) string {
+	switch {
+	case strings.HasPrefix(s, "0x"):
+		return "<addr>"
+	case strings.HasPrefix(s, "/"):
+		return "<tmp>"
+	case strings.HasPrefix(s, "["):
+		host, _, _ := strings.Cut(s, "]:")
+		return host + "]:<port>"
+	case strings.HasPrefix(s, "localhost:"), strings.Count(s, ".") == 3 && strings.Contains(s, ":"):
+		host, _, _ := strings.Cut(s, ":")
+		return host + ":<port>"
+	case strings.Count(s, ".") == 3:
+		return s
+	case strings.Contains(s, ":"):
+		return "<time>"
+	case strings.TrimRight(s, "0123456789.") != "":
+		return "<duration>"
+	default:
+		return "<n>"
+	}
+}
+
+// NormalizeError replaces addresses, temporary paths, ports, durations and
+// numbers in an error message with placeholders.
+func NormalizeError(msg string) string {
+	return signatureRe.ReplaceAllStringFunc(msg, noisePlaceholder)
+}
+
+// FailureMessage returns the first error message of a failed test: the panic
+// message, the testify error or the first file:line: message.
+func (es Events) FailureMessage() string {
+	if e := es.FindFirstByAction(ActionPanic); e != nil {
+		lines := strings.SplitN(e.Output, "\n", 3)
+		msg := lines[0]
+		if len(lines) > 1 && strings.HasPrefix(lines[1], "→ ") {
+			fn, _, _ := strings.Cut(strings.TrimPrefix(lines[1], "→ "), "  ")
+			msg += " in " + fn
+		}
+		return msg
+	}
+	if es.FindFirstByAction(ActionTimeout) != nil {
+		return "test timed out"
+	}
+
+	hasTypes := es.HasOutputTypes()
+	var lines []string
+	for _, e := range es {
+		if e.Action == ActionOutput && (!hasTypes || e.IsError()) {
+			lines = append(lines, strings.TrimSuffix(e.Output, "\n"))
+		}
+	}
+	f, start, _, isTestify := ParseTestify(lines)
+	for i, line := range lines {
+		if isTestify && i == start {
+			return f.Message()
+		}
+		_, loc, ok := ParseLocation(line)
+		if !ok {
+			continue
+		}
+		if loc.Message == "" && i+1 < len(lines) {
+			return strings.TrimSpace(lines[i+1])
+		}
+		return loc.Message
+	}
+	return ""
+}
+
+// Cluster is a group of failed tests with the same normalized error.
+type Cluster struct {
+	Signature string
+	Keys      []Key
+}
+
+// Clusters groups the failed, timed out and panicked tests without failed
+// subtests by their normalized failure message, most common first.
+func (ts TestStorage) Clusters() []Cluster {
+	failed := ts.FindByAction(ActionFail).
+		Union(ts.FindByAction(ActionTimeout), ts.FindByAction(ActionPanic)).
+		FilterPackageResults()
+	var (
+		clusters []Cluster
+		index    = make(map[string]int)
+	)
+	for _, key := range failed.LeafTests() {
+		msg := ts[key].FailureMessage()
+		if msg == "" {
+			continue
+		}
+		sig := NormalizeError(msg)
+		i, ok := index[sig]
+		if !ok {
+			i = len(clusters)
+			index[sig] = i
+			clusters = append(clusters, Cluster{Signature: sig})
+		}
+		clusters[i].Keys = append(clusters[i].Keys, key)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i].Keys) > len(clusters[j].Keys)
+	})
+	return clusters
+}
+
+// PrintClusterSummary prints the failure messages shared by more than one
+// test with the tests that failed with them. Only the tests that pass the
+// attribute filter of flags are clustered.
+func (ts TestStorage) PrintClusterSummary(flags Flags) {
+	var printedHeader bool
+	for _, c := range flags.Attr.Filter(ts, ts).Clusters() {
+		if len(c.Keys) < 2 {
+			continue
+		}
+		if !printedHeader {
+			hr := failColor("════════════")
+			fmt.Println(hr, failColorBold("CLUSTERS"), hr)
+			printedHeader = true
+		}
+		fmt.Println(failColorBold(fmt.Sprintf("  %d tests:", len(c.Keys))) + " " + failColor(c.Signature))
+		keys := c.Keys
+		if flags.V < V1 && len(keys) > maxClusterList {
+			keys = keys[:maxClusterList]
+		}
+		for _, key := range keys {
+			name := packageColor(key.Package) + "." + testColor(key.Test)
+			if key.Variant != "" {
+				name += "  " + variantColor("["+key.Variant+"]")
+			}
+			fmt.Println("       " + name)
+		}
+		if n := len(c.Keys) - len(keys); n > 0 {
+			fmt.Println(noneColor(fmt.Sprintf("       ... %d more, TGO_V=1 to list all", n)))
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// diffKind is the role of an output line in a diff.
+type diffKind int
+
+const (
+	diffNone diffKind = iota
+	diffHeader
+	diffContext
+	diffRemove
+	diffAdd
+	diffHunk
+)
+
+// maxDiffLines is the length above which diffs are collapsed to the changed
+// lines and diffContextLines lines around them.
+const (
+	maxDiffLines     = 50
+	diffContextLines = 3
+)
+
+// cmpHeaderRe matches the "(-want +got)" legend go-cmp diffs are printed with.
+var cmpHeaderRe = regexp.MustCompile(`\([-+]\w+,? [-+]\w+\)`)
+
+var diffColors = map[diffKind]func(a ...any) string{
+	diffHeader:  defaultColor,
+	diffContext: defaultColor,
+	diffRemove:  failColor,
+	diffAdd:     passColor,
+	diffHunk:    timeColor,
+}
+
+// leadingSpace returns the number of leading spaces and tabs of line.
+func leadingSpace(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// diffLines finds go-cmp and unified diffs and expected/actual pairs in
+// output lines and returns the diff role of every line. When collapse is set,
+// unchanged lines far from any change in long diffs are marked as hidden.
+func diffLines(lines []string, collapse bool) (kinds []diffKind, hidden []bool) {
+	kinds = make([]diffKind, len(lines))
+	hidden = make([]bool, len(lines))
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimLeft(lines[i], " \t")
+		start := -1
+		switch {
+		case strings.HasPrefix(trimmed, "--- ") && i+1 < len(lines) &&
+			strings.HasPrefix(strings.TrimLeft(lines[i+1], " \t"), "+++ "):
+			kinds[i], kinds[i+1] = diffHeader, diffHeader
+			start = i + 2
+		case cmpHeaderRe.MatchString(lines[i]):
+			kinds[i] = diffHeader
+			start = i + 1
+		case strings.HasPrefix(trimmed, "expected:"), strings.HasPrefix(trimmed, "want:"):
+			kinds[i] = diffRemove
+		case strings.HasPrefix(trimmed, "actual:"), strings.HasPrefix(trimmed, "actual  :"),
+			strings.HasPrefix(trimmed, "got:"):
+			kinds[i] = diffAdd
+		}
+		if start < 0 {
+			continue
+		}
+
+		end := start
+		for end < len(lines) && !endsDiff(lines[end]) {
+			end++
+		}
+		if end == start {
+			continue
+		}
+		base := leadingSpace(lines[start])
+		for _, line := range lines[start:end] {
+			base = min(base, leadingSpace(line))
+		}
+		for j := start; j < end; j++ {
+			var c byte
+			if base < len(lines[j]) {
+				c = lines[j][base]
+			}
+			switch c {
+			case '-':
+				kinds[j] = diffRemove
+			case '+':
+				kinds[j] = diffAdd
+			case '@':
+				kinds[j] = diffHunk
+			default:
+				kinds[j] = diffContext
+			}
+		}
+		if collapse && end-start > maxDiffLines {
+			collapseDiff(kinds[start:end], hidden[start:end])
+		}
+		i = end - 1
+	}
+	return kinds, hidden
+}
+
+// endsDiff reports if line can't be part of a diff printed by a test.
+func endsDiff(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return true
+	}
+	if _, _, ok := ParseLocation(line); ok {
+		return true
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	return strings.HasPrefix(trimmed, "--- FAIL") ||
+		strings.HasPrefix(trimmed, "--- PASS") ||
+		strings.HasPrefix(trimmed, "--- SKIP") ||
+		strings.HasPrefix(trimmed, "=== ")
+}
+
+// collapseDiff hides the context lines further than diffContextLines from a
+// change.
+func collapseDiff(kinds []diffKind, hidden []bool) {
+	for i := range kinds {
+		hidden[i] = true
+	}
+	for i, k := range kinds {
+		if k == diffContext {
+			continue
+		}
+		for j := max(0, i-diffContextLines); j <= min(len(kinds)-1, i+diffContextLines); j++ {
+			hidden[j] = false
+		}
+	}
+}
+
+// collapsedLine is printed in place of hidden diff lines.
+func collapsedLine(n int) string {
+	return noneColor(fmt.Sprintf("        ··· %d unchanged lines ···", n))
+}
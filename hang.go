@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// runningTest is a test that has started or continued but not ended.
+type runningTest struct {
+	since  time.Time
+	warned bool
+	gt     *GoTest
+}
+
+// hangDetector warns about tests that have been running for too long and can
+// make their test binary dump its goroutines.
+type hangDetector struct {
+	bin     string
+	after   time.Duration
+	quit    bool
+	running map[Key]*runningTest
+	quitted map[Key]Key // package key to the hanging test it was quit for
+}
+
+func newHangDetector(bin string, after time.Duration, quit bool) *hangDetector {
+	return &hangDetector{
+		bin:     bin,
+		after:   after,
+		quit:    quit,
+		running: make(map[Key]*runningTest),
+		quitted: make(map[Key]Key),
+	}
+}
+
+// Event tracks which tests are running. Output of a package that was quit is
+// returned as output of the hanging test so the goroutine dump ends up in its
+// detail only.
+func (hd *hangDetector) Event(e Event) Event {
+	key := e.Key()
+	switch e.Action {
+	case ActionRun, ActionCont:
+		if key.Test != "" {
+			hd.running[key] = &runningTest{since: time.Now(), gt: e.GoTest}
+		}
+	case ActionPause, ActionPass, ActionFail, ActionSkip, ActionBench, ActionTimeout:
+		delete(hd.running, key)
+	case ActionOutput:
+		pkgKey := key
+		pkgKey.Test = ""
+		if hung, ok := hd.quitted[pkgKey]; ok && e.OutputType != OutputTypeFrame {
+			e.Test = hung.Test
+		}
+	}
+	return e
+}
+
+// Check warns once about every test that has been running longer than the
+// threshold, tests with running subtests are not reported.
+func (hd *hangDetector) Check(now time.Time, tests TestStorage) {
+	running := make(TestStorage, len(hd.running))
+	for key := range hd.running {
+		running[key] = nil
+	}
+	for _, key := range running.OrderedKeys() {
+		rt := hd.running[key]
+		if rt.warned || now.Sub(rt.since) < hd.after {
+			continue
+		}
+		if hd.hasRunningSubtest(key) {
+			continue
+		}
+		rt.warned = true
+		d := now.Sub(rt.since).Round(time.Second)
+		fmt.Println(noneColorBold("=== HANG") + " " + noneColor(key.String()) + "  " + timeColor(fmt.Sprintf("running for %s", d)))
+
+		note := fmt.Sprintf("hanging, running for over %s", d)
+		pkgKey := key
+		pkgKey.Test = ""
+		if _, ok := hd.quitted[pkgKey]; hd.quit && !ok && rt.gt != nil {
+			if err := rt.gt.SignalTestBinary(hd.bin, key.Package, syscall.SIGQUIT); err != nil {
+				fmt.Println(failColor("sending SIGQUIT: " + err.Error()))
+			} else {
+				hd.quitted[pkgKey] = key
+				note += ", sent SIGQUIT"
+			}
+		}
+		tests.Note(key, note)
+	}
+}
+
+func (hd *hangDetector) hasRunningSubtest(key Key) bool {
+	for other := range hd.running {
+		if other.IsSubtestOf(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// History is what tgo remembers between runs, it is stored as json in the
+// user cache directory with one file per working directory.
+type History struct {
+	Packages map[string]PackageHistory `json:"packages"`
+	Tests    map[string]LastPass       `json:"tests,omitempty"`
+
+	path string
+}
+
+// PackageHistory is the last known result of a package.
+type PackageHistory struct {
+	Elapsed float64   `json:"elapsed"` // seconds
+	Time    time.Time `json:"time"`
+}
+
+// historyMaxAge is how long packages and tests that are no longer run are
+// kept in the history.
+const historyMaxAge = 30 * 24 * time.Hour
+
+// DefaultHistoryPath returns the history file for the working directory.
+func DefaultHistoryPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(wd))
+	return filepath.Join(dir, "tgo", hex.EncodeToString(sum[:8])+".json")
+}
+
+// LoadHistory reads the history at path, a missing file is an empty history.
+func LoadHistory(path string) (*History, error) {
+	h := &History{
+		Packages: make(map[string]PackageHistory),
+		Tests:    make(map[string]LastPass),
+		path:     path,
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, err
+	}
+	if h.Packages == nil {
+		h.Packages = make(map[string]PackageHistory)
+	}
+	if h.Tests == nil {
+		h.Tests = make(map[string]LastPass)
+	}
+	return h, nil
+}
+
+// Save writes the history back to where it was loaded from.
+func (h *History) Save() error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// Record stores the elapsed time of every finished package and the output of
+// passing tests. Packages and tests not seen for historyMaxAge are forgotten.
+func (h *History) Record(ts TestStorage) {
+	h.recordOutput(ts)
+	for key, events := range ts.FindPackageResults() {
+		e := events.FindFirstByAction(EndingActions...)
+		if e == nil || key.Variant != "" || events.IsPackageWithoutTest() {
+			continue
+		}
+		h.Packages[key.Package] = PackageHistory{
+			Elapsed: e.Elapsed,
+			Time:    e.Time,
+		}
+	}
+	for pkg, ph := range h.Packages {
+		if time.Since(ph.Time) > historyMaxAge {
+			delete(h.Packages, pkg)
+		}
+	}
+	for hk, lp := range h.Tests {
+		if time.Since(lp.Time) > historyMaxAge {
+			delete(h.Tests, hk)
+		}
+	}
+}
+
+// Elapsed returns the last recorded elapsed time of a package.
+func (h *History) Elapsed(pkg string) (float64, bool) {
+	if h == nil {
+		return 0, false
+	}
+	ph, ok := h.Packages[pkg]
+	return ph.Elapsed, ok
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// maxIsolate is the most failed tests that are run again in isolation.
+const maxIsolate = 50
+
+// Isolation classifications added as notes to failed tests.
+const (
+	isolationFails        = "fails in isolation"
+	isolationPasses       = "passes alone, failed in suite"
+	isolationInconclusive = "inconclusive in isolation"
+)
+
+// FailedLeafTests returns the keys of failed tests that have no failed
+// subtests.
+func (ts TestStorage) FailedLeafTests() []Key {
+	return ts.FindByAction(ActionFail).FilterPackageResults().LeafTests()
+}
+
+// LeafTests returns the ordered keys of the results that have no subtests
+// among the results.
+func (ts TestStorage) LeafTests() []Key {
+	var keys []Key
+loop:
+	for _, key := range ts.OrderedKeys() {
+		for other := range ts {
+			if other.IsSubtestOf(key) {
+				continue loop
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Isolate runs every failed test again alone in a fresh process and adds a
+// note to it saying if it failed again.
+func (ts TestStorage) Isolate(ctx context.Context, flags Flags) {
+	keys := ts.FailedLeafTests()
+	if len(keys) == 0 {
+		return
+	}
+	if len(keys) > maxIsolate {
+		keys = keys[:maxIsolate]
+	}
+	fmt.Printf("isolating %d failed tests\n", len(keys))
+
+	var gts []*GoTest
+	for _, key := range keys {
+		src := ts[key][0].GoTest
+		gt := &GoTest{Args: []string{"-run=" + RunPattern(key.Test), "-count=1", key.Package}}
+		if src != nil {
+			ta := ParseTestArgs(src.Args)
+			args := ta.ReproFlags("run", "skip", "count", "shuffle", "failfast")
+			gt = &GoTest{
+				Dir:     src.Dir,
+				Module:  src.Module,
+				Variant: src.Variant,
+				Env:     src.Env,
+				Args:    append(args, gt.Args...),
+			}
+		}
+		gts = append(gts, gt)
+	}
+
+	isolated := make(TestStorage)
+	events := make(chan Event)
+	go func() {
+		_ = runGoTests(ctx, flags.Bin, gts, runtime.NumCPU(), events)
+	}()
+	for e := range events {
+		isolated.Append(e)
+	}
+
+	for _, key := range keys {
+		note := isolationInconclusive
+		switch isolated[key].Status() {
+		case StatusFail:
+			note = isolationFails
+		case StatusPass:
+			note = isolationPasses
+		}
+		ts.Note(key, note)
+	}
+}
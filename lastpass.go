@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxHistoryOutput is the number of output lines remembered per test.
+const maxHistoryOutput = 200
+
+// LastPass is the output of the last run where a test passed.
+type LastPass struct {
+	Output []string  `json:"output"`
+	Time   time.Time `json:"time"`
+}
+
+// testHistoryKey is the key of a test in History.Tests.
+func testHistoryKey(key Key) string {
+	return key.Package + " " + key.Test
+}
+
+// CompactOutput returns the output lines of a test without the lines go test
+// adds around it.
+func (es Events) CompactOutput() []string {
+	var lines []string
+	for _, e := range es.Compact() {
+		if e.Action != ActionOutput {
+			continue
+		}
+		if line := strings.TrimRight(e.Output, "\n"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// recordOutput remembers the output of passing tests that printed something.
+func (h *History) recordOutput(ts TestStorage) {
+	for key, events := range ts {
+		if key.Test == "" || key.Variant != "" || events.Status() != StatusPass {
+			continue
+		}
+		lines := events.CompactOutput()
+		if len(lines) == 0 {
+			delete(h.Tests, testHistoryKey(key))
+			continue
+		}
+		if len(lines) > maxHistoryOutput {
+			lines = lines[:maxHistoryOutput]
+		}
+		e := events.FindFirstByAction(ActionPass)
+		h.Tests[testHistoryKey(key)] = LastPass{Output: lines, Time: e.Time}
+	}
+}
+
+// outputNoiseRe matches the noise in output lines.
+var outputNoiseRe = regexp.MustCompile(strings.Join(noisePatterns, "|"))
+
+// normalizeOutput replaces the parts of an output line that change between
+// runs with a placeholder.
+func normalizeOutput(line string) string {
+	return outputNoiseRe.ReplaceAllStringFunc(line, noisePlaceholder)
+}
+
+// lineDiff returns the lines of a and b marked as removed, added or common
+// using a longest common subsequence of the normalized lines.
+func lineDiff(a, b []string) (lines []string, kinds []diffKind) {
+	na := make([]string, len(a))
+	for i, l := range a {
+		na[i] = normalizeOutput(l)
+	}
+	nb := make([]string, len(b))
+	for i, l := range b {
+		nb[i] = normalizeOutput(l)
+	}
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if na[i] == nb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && na[i] == nb[j]:
+			lines, kinds = append(lines, "  "+b[j]), append(kinds, diffContext)
+			i, j = i+1, j+1
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines, kinds = append(lines, "+ "+b[j]), append(kinds, diffAdd)
+			j++
+		default:
+			lines, kinds = append(lines, "- "+a[i]), append(kinds, diffRemove)
+			i++
+		}
+	}
+	return lines, kinds
+}
+
+// PrintLastPassDiff prints how the output of a failed test differs from the
+// last run where it passed.
+func (h *History) PrintLastPassDiff(key Key, events Events) {
+	if h == nil || key.Test == "" || key.Variant != "" {
+		return
+	}
+	lp, ok := h.Tests[testHistoryKey(key)]
+	if !ok {
+		return
+	}
+	// the diff is quadratic in the number of lines, compare no more than
+	// what is remembered of the passing run
+	cur := events.CompactOutput()
+	skipped := max(0, len(cur)-maxHistoryOutput)
+	cur = cur[:len(cur)-skipped]
+	lines, kinds := lineDiff(lp.Output, cur)
+	header := "    ── output since last pass " + lp.Time.Local().Format("2006-01-02 15:04")
+	changed := skipped > 0
+	for _, k := range kinds {
+		changed = changed || k != diffContext
+	}
+	if !changed {
+		fmt.Println(noneColor(header + ": unchanged"))
+		fmt.Println("")
+		return
+	}
+	fmt.Println(noneColor(header))
+	hidden := make([]bool, len(lines))
+	collapseDiff(kinds, hidden)
+	for i, line := range lines {
+		if hidden[i] {
+			if i+1 == len(hidden) || !hidden[i+1] {
+				n := 1
+				for n <= i && hidden[i-n] {
+					n++
+				}
+				fmt.Println(collapsedLine(n))
+			}
+			continue
+		}
+		fmt.Println("    " + diffColors[kinds[i]](line))
+	}
+	if skipped > 0 {
+		fmt.Println(noneColor(fmt.Sprintf("        ··· %d more lines not compared ···", skipped)))
+	}
+	fmt.Println("")
+}
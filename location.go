@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// Location is a position in a source file that a message refers to.
+type Location struct {
+	File    string
+	Line    int
+	Col     int
+	Message string
+}
+
+// locationRe matches the file:line: prefix of t.Errorf output and compiler
+// errors, optionally with a column.
+var locationRe = regexp.MustCompile(`^(\s*)([^\s:]+\.go):(\d+):(?:(\d+):)?`)
+
+// ParseLocation splits a line of output starting with file.go:line: into its
+// indentation and location.
+func ParseLocation(line string) (indent string, loc Location, ok bool) {
+	m := locationRe.FindStringSubmatchIndex(line)
+	if m == nil {
+		return "", loc, false
+	}
+	indent = line[m[2]:m[3]]
+	loc.File = line[m[4]:m[5]]
+	loc.Line, _ = strconv.Atoi(line[m[6]:m[7]])
+	if m[8] >= 0 {
+		loc.Col, _ = strconv.Atoi(line[m[8]:m[9]])
+	}
+	loc.Message = strings.TrimSpace(line[m[1]:])
+	return indent, loc, true
+}
+
+// Resolve returns the location with its file joined to dir when relative.
+func (l Location) Resolve(dir string) Location {
+	if dir != "" && !filepath.IsAbs(l.File) {
+		l.File = filepath.Join(dir, l.File)
+	}
+	return l
+}
+
+// String formats the location as path:line:col.
+func (l Location) String() string {
+	s := l.File + ":" + strconv.Itoa(l.Line)
+	if l.Col > 0 {
+		s += ":" + strconv.Itoa(l.Col)
+	}
+	return s
+}
+
+// packageDirs caches the directories of packages looked up with go list.
+var packageDirs = struct {
+	sync.Mutex
+	dirs map[string]string
+}{dirs: make(map[string]string)}
+
+// PackageDir returns the directory of a package as seen from the directory
+// of the go test run that tested it, or "" if it can't be found.
+func PackageDir(bin string, gt *GoTest, pkg string) string {
+	var dir string
+	if gt != nil {
+		dir = gt.Dir
+	}
+	cacheKey := dir + "\x00" + pkg
+	packageDirs.Lock()
+	defer packageDirs.Unlock()
+	if d, ok := packageDirs.dirs[cacheKey]; ok {
+		return d
+	}
+	var d string
+	if pkgs, err := goList(context.Background(), bin, dir, pkg); err == nil && len(pkgs) == 1 {
+		d = pkgs[0].Dir
+	}
+	packageDirs.dirs[cacheKey] = d
+	return d
+}
+
+// LocationDir returns the directory that locations in the output of the event
+// are relative to. Compiler errors are relative to where go test ran, test
+// output to the directory of the package.
+func (e Event) LocationDir(bin string) string {
+	if e.ImportPath == "" {
+		return PackageDir(bin, e.GoTest, e.Package)
+	}
+	if e.GoTest != nil && e.GoTest.Dir != "" {
+		return e.GoTest.Dir
+	}
+	wd, _ := os.Getwd()
+	return wd
+}
+
+// hyperlink wraps text in an OSC 8 terminal hyperlink to the location using
+// an editor url template with {path}, {line} and {col} placeholders.
+func hyperlink(template string, loc Location, text string) string {
+	if template == "" || color.NoColor {
+		return text
+	}
+	url := strings.NewReplacer(
+		"{path}", filepath.ToSlash(loc.File),
+		"{line}", strconv.Itoa(loc.Line),
+		"{col}", strconv.Itoa(max(loc.Col, 1)),
+	).Replace(template)
+	return "\x1b]8;;" + url + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
+
+// linkLocation renders an output line that starts with a location with the
+// path made relative to the working directory and linked to the editor.
+func linkLocation(flags Flags, e Event, line string, textColor func(a ...any) string) string {
+	indent, loc, ok := ParseLocation(line)
+	if !ok {
+		return textColor(line)
+	}
+	dir := e.LocationDir(flags.Bin)
+	if dir == "" {
+		return textColor(line)
+	}
+	loc = loc.Resolve(dir)
+	rest := line[len(locationRe.FindString(line)):]
+	name := ShortPath(loc.File, "") + strings.TrimPrefix(loc.String(), loc.File) + ":"
+	return indent + hyperlink(flags.EditorURL, loc, textColor(name)) + textColor(rest)
+}
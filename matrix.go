@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Dimension is one axis of a test matrix.
+//
+// The name is either a go test flag like "tags" or "race" or an environment
+// variable prefixed with "env:". Flag values "" and "off" leave the flag out
+// and "on" passes it without a value.
+type Dimension struct {
+	Name   string
+	Values []string
+}
+
+// Matrix is a set of dimensions, every combination of their values is run as
+// a separate go test invocation.
+type Matrix []Dimension
+
+// for flag
+func (m *Matrix) String() string {
+	var r []string
+	for _, d := range *m {
+		r = append(r, d.Name+"="+strings.Join(d.Values, ","))
+	}
+	return strings.Join(r, ";")
+}
+
+// for flag, dimensions are separated by ';' and can also be added by
+// repeating the flag.
+func (m *Matrix) Set(value string) error {
+	for _, v := range strings.Split(value, ";") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(v, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || name == "env:" {
+			return fmt.Errorf("%s is not a valid matrix dimension, expected name=value,value", v)
+		}
+		*m = append(*m, Dimension{
+			Name:   name,
+			Values: strings.Split(values, ","),
+		})
+	}
+	return nil
+}
+
+// Cell is one combination of matrix values.
+type Cell struct {
+	Label string   // "tags=integration race=on"
+	Flags []string // added to the go test arguments
+	Env   []string // added to the environment
+}
+
+// Cells returns every combination of the dimension values in order.
+func (m Matrix) Cells() []Cell {
+	if len(m) == 0 {
+		return nil
+	}
+	cells := []Cell{{}}
+	for _, d := range m {
+		var next []Cell
+		for _, c := range cells {
+			for _, value := range d.Values {
+				cell := Cell{
+					Flags: slices.Clone(c.Flags),
+					Env:   slices.Clone(c.Env),
+				}
+				label := value
+				if label == "" {
+					label = "-"
+				}
+				cell.Label = strings.TrimSpace(c.Label + " " + d.Name + "=" + label)
+				if name, ok := strings.CutPrefix(d.Name, "env:"); ok {
+					cell.Env = append(cell.Env, name+"="+value)
+				} else {
+					switch value {
+					case "", "off":
+					case "on":
+						cell.Flags = append(cell.Flags, "-"+d.Name)
+					default:
+						cell.Flags = append(cell.Flags, "-"+d.Name+"="+value)
+					}
+				}
+				next = append(next, cell)
+			}
+		}
+		cells = next
+	}
+	return cells
+}
+
+// matrixTests returns a go test run for every cell of the matrix for each of
+// the given runs.
+func matrixTests(m Matrix, gts []*GoTest) []*GoTest {
+	cells := m.Cells()
+	if len(cells) == 0 {
+		return gts
+	}
+	var result []*GoTest
+	for _, cell := range cells {
+		for _, gt := range gts {
+			ta := ParseTestArgs(gt.Args)
+			ta.Flags = append(slices.Clone(ta.Flags), cell.Flags...)
+			result = append(result, &GoTest{
+				Dir:     gt.Dir,
+				Module:  gt.Module,
+				Variant: cell.Label,
+				Args:    ta.Argv(),
+				Env:     append(slices.Clone(gt.Env), cell.Env...),
+			})
+		}
+	}
+	return result
+}
+
+// PrintVariantSummary prints the tests that failed in some matrix cells but
+// not in all of them.
+func (ts TestStorage) PrintVariantSummary() {
+	byKey := make(map[Key]map[Status][]string)
+	var keys []Key
+	for _, key := range ts.OrderedKeys() {
+		base := key
+		base.Variant = ""
+		if _, ok := byKey[base]; !ok {
+			byKey[base] = make(map[Status][]string)
+			keys = append(keys, base)
+		}
+		status := ts[key].Status()
+		byKey[base][status] = append(byKey[base][status], key.Variant)
+	}
+
+	hr := noneColor("════════════")
+	var printedHeader bool
+	for _, key := range keys {
+		statuses := byKey[key]
+		failed := false
+		for status := range statuses {
+			failed = failed || status.Failed()
+		}
+		if !failed || len(statuses) == 1 {
+			continue
+		}
+		if !printedHeader {
+			fmt.Println(hr, noneColorBold("VARIANTS"), hr)
+			printedHeader = true
+		}
+		name := packageColor(key.Package)
+		if key.Test != "" {
+			name += "." + testColor(key.Test)
+		}
+		var parts []string
+		for _, status := range AllStatuses {
+			if vs, ok := statuses[status]; ok {
+				parts = append(parts, statusColors[status](statusNames[status]+": "+strings.Join(vs, ", ")))
+			}
+		}
+		fmt.Println("  " + name + "  " + strings.Join(parts, " | "))
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go/build"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Module is a go module found below the working directory.
+type Module struct {
+	Path string // module path from go.mod
+	Dir  string // directory containing go.mod
+}
+
+// FindModules returns the modules listed in go.work if there is one in dir,
+// otherwise every go.mod found below dir.
+func FindModules(ctx context.Context, bin string, dir string) ([]Module, error) {
+	if _, err := os.Stat(filepath.Join(dir, "go.work")); err == nil {
+		return workModules(ctx, bin, dir)
+	}
+
+	var modules []Module
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != dir && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		m, err := readModule(path)
+		if err != nil {
+			return err
+		}
+		modules = append(modules, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Dir < modules[j].Dir
+	})
+	return modules, nil
+}
+
+func workModules(ctx context.Context, bin string, dir string) ([]Module, error) {
+	cmd := exec.CommandContext(ctx, bin, "work", "edit", "-json")
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var work struct {
+		Use []struct {
+			DiskPath string
+		}
+	}
+	if err := json.NewDecoder(bytes.NewReader(out)).Decode(&work); err != nil {
+		return nil, err
+	}
+	var modules []Module
+	for _, use := range work.Use {
+		path := use.DiskPath
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		m, err := readModule(filepath.Join(path, "go.mod"))
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, m)
+	}
+	return modules, nil
+}
+
+func readModule(gomod string) (Module, error) {
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return Module{}, err
+	}
+	return Module{
+		Path: modulePath(data),
+		Dir:  filepath.Dir(gomod),
+	}, nil
+}
+
+// moduleTests returns one go test run per module found below the working
+// directory with the package patterns that belong to it, modules without
+// selected packages are left out.
+func moduleTests(ctx context.Context, flags Flags, argv []string) ([]*GoTest, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	modules, err := FindModules(ctx, flags.Bin, wd)
+	if err != nil {
+		return nil, err
+	}
+	ta := ParseTestArgs(argv)
+	if len(ta.Packages) == 0 {
+		ta = ta.WithPackages("./...")
+	}
+	patterns := ModulePatterns(wd, modules, ta.Packages)
+	var gts []*GoTest
+	for _, m := range modules {
+		if len(patterns[m.Dir]) == 0 {
+			continue
+		}
+		gts = append(gts, &GoTest{
+			Dir:    m.Dir,
+			Module: m.Path,
+			Args:   ta.WithPackages(patterns[m.Dir]...).Argv(),
+		})
+	}
+	return gts, nil
+}
+
+// ModulePatterns splits package patterns given in wd by the module that owns
+// them, keyed by module directory. Directory patterns are made relative to
+// the module, a ./... pattern covers every module below it.
+func ModulePatterns(wd string, modules []Module, patterns []string) map[string][]string {
+	byDir := make(map[string][]string)
+	add := func(m Module, pattern string) {
+		if !slices.Contains(byDir[m.Dir], pattern) {
+			byDir[m.Dir] = append(byDir[m.Dir], pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		base, recursive := strings.CutSuffix(pattern, "/...")
+		if pattern == "..." {
+			base, recursive = "", true
+		}
+		if !build.IsLocalImport(base) && !filepath.IsAbs(base) {
+			// an import path pattern
+			for _, m := range modules {
+				if recursive && base != m.Path && isUnder(m.Path, base, "/") {
+					add(m, "./...")
+				}
+			}
+			if m, ok := owner(modules, base, func(m Module) string { return m.Path }, "/"); ok {
+				add(m, pattern)
+			}
+			continue
+		}
+		dir := base
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(wd, dir)
+		}
+		for _, m := range modules {
+			if recursive && m.Dir != dir && isUnder(m.Dir, dir, string(filepath.Separator)) {
+				add(m, "./...")
+			}
+		}
+		m, ok := owner(modules, dir, func(m Module) string { return m.Dir }, string(filepath.Separator))
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(m.Dir, dir)
+		if err != nil {
+			continue
+		}
+		switch {
+		case rel == "." && recursive:
+			rel = "./..."
+		case rel == ".":
+		case recursive:
+			rel = "./" + filepath.ToSlash(rel) + "/..."
+		default:
+			rel = "./" + filepath.ToSlash(rel)
+		}
+		add(m, rel)
+	}
+	return byDir
+}
+
+// isUnder reports if path is parent or below it, with sep separating path
+// elements.
+func isUnder(path, parent, sep string) bool {
+	return path == parent || parent == "" || strings.HasPrefix(path, strings.TrimSuffix(parent, sep)+sep)
+}
+
+// owner returns the module with the longest path or directory that path is
+// part of.
+func owner(modules []Module, path string, key func(Module) string, sep string) (Module, bool) {
+	var (
+		best  Module
+		found bool
+	)
+	for _, m := range modules {
+		if isUnder(path, key(m), sep) && (!found || len(key(m)) > len(key(best))) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
+
+// modulePath returns the module path from the contents of a go.mod file.
+func modulePath(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "module") {
+			continue
+		}
+		path := strings.TrimSpace(strings.TrimPrefix(line, "module"))
+		path, _, _ = strings.Cut(path, "//")
+		path = strings.TrimSpace(path)
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			path = unquoted
+		}
+		return path
+	}
+	return ""
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Frame is one function call of a goroutine stack trace.
+type Frame struct {
+	Func      string // full function name without arguments
+	File      string
+	Line      int
+	CreatedBy bool // the frame is the "created by" line of the goroutine
+}
+
+// IsRuntime reports if the frame belongs to the runtime or testing packages.
+func (f Frame) IsRuntime() bool {
+	for _, prefix := range []string{"runtime.", "testing.", "panic(", "internal/", "created by runtime"} {
+		if strings.HasPrefix(f.Func, prefix) {
+			return true
+		}
+	}
+	return f.Func == "panic" || f.Func == "main.main"
+}
+
+// ShortFunc returns the function name without the import path directory.
+func (f Frame) ShortFunc() string {
+	if i := strings.LastIndex(f.Func, "/"); i >= 0 {
+		return f.Func[i+1:]
+	}
+	return f.Func
+}
+
+// TestName returns the name of the top level test function the frame belongs
+// to, if any. TestMain is not a test.
+func (f Frame) TestName() string {
+	parts := strings.Split(f.ShortFunc(), ".")
+	if len(parts) < 2 || parts[0] == "testing" || parts[1] == "TestMain" {
+		return ""
+	}
+	for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+		rest, ok := strings.CutPrefix(parts[1], prefix)
+		if !ok {
+			continue
+		}
+		// like go test, TestFoo and Test_foo are tests but Testfoo is not
+		if r, _ := utf8.DecodeRuneInString(rest); rest == "" || !unicode.IsLower(r) {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// Panic is a panic or fatal error message with the stack of the goroutine
+// that caused it.
+type Panic struct {
+	Message []string
+	Frames  []Frame
+}
+
+// ParsePanic finds the first panic or fatal error in the output of a test
+// binary. Test timeouts are not returned, they are handled by ParseTimeout.
+func ParsePanic(lines []string) (Panic, bool) {
+	var p Panic
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic: test timed out after ") {
+			return p, false
+		}
+		if isPanicLine(line) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return p, false
+	}
+
+	i := start
+	for ; i < len(lines) && !strings.HasPrefix(lines[i], "goroutine "); i++ {
+		if line := strings.TrimRight(lines[i], " \t"); line != "" {
+			p.Message = append(p.Message, line)
+		}
+	}
+	if i < len(lines) {
+		i++ // goroutine header
+	}
+	for ; i+1 < len(lines); i += 2 {
+		fn, loc := lines[i], lines[i+1]
+		if fn == "" || !strings.HasPrefix(loc, "\t") {
+			break
+		}
+		p.Frames = append(p.Frames, parseFrame(fn, loc))
+	}
+	return p, true
+}
+
+// parseFrame parses the function and location lines of a stack frame.
+func parseFrame(fn, loc string) Frame {
+	fn = strings.TrimSpace(fn)
+	frame := Frame{Func: fn}
+	if name, ok := strings.CutPrefix(fn, "created by "); ok {
+		name, _, _ = strings.Cut(name, " in goroutine ")
+		frame.Func = name
+		frame.CreatedBy = true
+	} else if idx := strings.LastIndex(fn, "("); idx > 0 {
+		frame.Func = fn[:idx]
+	}
+	loc = strings.TrimSpace(loc)
+	loc, _, _ = strings.Cut(loc, " ")
+	if idx := strings.LastIndex(loc, ":"); idx > 0 {
+		frame.File = loc[:idx]
+		frame.Line, _ = strconv.Atoi(loc[idx+1:])
+	}
+	return frame
+}
+
+// TestName returns the innermost test function in the stack.
+func (p Panic) TestName() string {
+	for _, f := range p.Frames {
+		if name := f.TestName(); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// UserFrame returns the innermost frame outside of the runtime and testing
+// packages.
+func (p Panic) UserFrame() (Frame, bool) {
+	for _, f := range p.Frames {
+		if !f.IsRuntime() && !f.CreatedBy {
+			return f, true
+		}
+	}
+	return Frame{}, false
+}
+
+// goroot guesses GOROOT from the location of runtime or testing frames.
+func (p Panic) goroot() string {
+	for _, f := range p.Frames {
+		for _, dir := range []string{"/src/runtime/", "/src/testing/"} {
+			if idx := strings.Index(f.File, dir); idx > 0 {
+				return f.File[:idx]
+			}
+		}
+	}
+	return ""
+}
+
+// ShortPath returns path relative to the working directory, GOROOT or the
+// module cache when possible.
+func ShortPath(path string, goroot string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	if goroot != "" {
+		if rel, ok := strings.CutPrefix(path, goroot+"/src/"); ok {
+			return rel
+		}
+	}
+	if _, rel, ok := strings.Cut(path, "/pkg/mod/"); ok {
+		return rel
+	}
+	return path
+}
+
+// Condensed returns the panic message and a trace without runtime and testing
+// frames, the first frame in user code is marked with an arrow.
+func (p Panic) Condensed() string {
+	var sb strings.Builder
+	for _, line := range p.Message {
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	goroot := p.goroot()
+	user, hasUser := p.UserFrame()
+	for _, f := range p.Frames {
+		if f.IsRuntime() {
+			continue
+		}
+		marker := "  "
+		if hasUser && f == user {
+			marker = "→ "
+		}
+		name := f.ShortFunc()
+		if f.CreatedBy {
+			name = "created by " + name
+		}
+		fmt.Fprintf(&sb, "%s%s  %s:%d\n", marker, name, ShortPath(f.File, goroot), f.Line)
+	}
+	return sb.String()
+}
+
+// isPanicLine reports if a line of output starts a panic or fatal error that
+// is not a test timeout.
+func isPanicLine(line string) bool {
+	return (strings.HasPrefix(line, "panic: ") || strings.HasPrefix(line, "fatal error: ")) &&
+		!strings.HasPrefix(line, "panic: test timed out after ")
+}
+
+// HasPanic reports if the output contains a panic or fatal error.
+func (es Events) HasPanic() bool {
+	for _, e := range es {
+		if e.Action == ActionOutput && isPanicLine(e.Output) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkPanics finds a panic in output, the output of the package of key, and
+// adds a panic event to the test that caused it. It returns the key of that test,
+// packages that already have a panic event are left alone.
+func (ts TestStorage) MarkPanics(key Key, output []string) []Key {
+	for k, events := range ts {
+		if k.Package == key.Package && k.Module == key.Module && k.Variant == key.Variant &&
+			events.FindFirstByAction(ActionPanic) != nil {
+			return nil
+		}
+	}
+	p, ok := ParsePanic(output)
+	if !ok {
+		return nil
+	}
+	name := p.TestName()
+	if name == "" {
+		return nil
+	}
+
+	// The panic goes to the test of the stack frame or to the subtest of it
+	// that caused it: the one go test attributed the panic output to, else
+	// the innermost one that never finished or failed last. The goroutine
+	// dump is moved to the output of that test, its detail shows the
+	// condensed trace instead.
+	target := key
+	target.Test = name
+	holder := target
+	panicked, unfinished, failed := make(TestStorage), make(TestStorage), make(TestStorage)
+	for k, events := range ts {
+		if !k.IsSubtestOf(target) {
+			continue
+		}
+		switch {
+		case events.HasPanic():
+			panicked[k] = events
+		case events.FindFirstByAction(ActionRun) != nil && events.FindFirstByAction(EndingActions...) == nil:
+			unfinished[k] = events
+		case events.FindFirstByAction(ActionFail) != nil:
+			failed[k] = events
+		}
+	}
+	for _, candidates := range []TestStorage{panicked, unfinished, failed} {
+		if leaves := candidates.LeafTests(); len(leaves) > 0 {
+			target = slices.MaxFunc(leaves, func(a, b Key) int {
+				return candidates[a][len(candidates[a])-1].Time.Compare(candidates[b][len(candidates[b])-1].Time)
+			})
+			break
+		}
+	}
+	if len(panicked) == 0 && target != holder {
+		ts.moveTrace(holder, target)
+	}
+
+	ts.Append(Event{
+		Time:    time.Now(),
+		Action:  ActionPanic,
+		Module:  target.Module,
+		Package: target.Package,
+		Test:    target.Test,
+		Variant: target.Variant,
+		Output:  p.Condensed(),
+	})
+	return []Key{target}
+}
+
+// moveTrace moves the panic and goroutine dump in the output of from to the
+// output of to.
+func (ts TestStorage) moveTrace(from, to Key) {
+	var (
+		kept    Events
+		inTrace bool
+	)
+	for _, e := range ts[from] {
+		if e.Action == ActionOutput && (isPanicLine(e.Output) || inTrace && isTraceLine(e.Output)) {
+			inTrace = true
+			e.Test = to.Test
+			ts[to] = append(ts[to], e)
+			continue
+		}
+		inTrace = false
+		kept = append(kept, e)
+	}
+	ts[from] = kept
+}
+
+// crashNote is the output of the panic event of a test that was running when
+// its test binary exited, which is what os.Exit or a fatal signal look like.
+const crashNote = "test binary exited while the test was running\n"
+
+// MarkCrashes adds a panic event to the tests of the package of key that were
+// running when the test binary exited without a panic or timeout in output. Only the
+// innermost running tests are marked, it returns their keys.
+func (ts TestStorage) MarkCrashes(key Key, output []string) []Key {
+	for _, line := range output {
+		// goroutine dumps requested with -hang-quit are not crashes
+		if strings.HasPrefix(line, "SIGQUIT: quit") {
+			return nil
+		}
+	}
+	running := make(TestStorage)
+	for k, events := range ts {
+		if k.Test != "" && k.Package == key.Package && k.Module == key.Module && k.Variant == key.Variant &&
+			events.FindFirstByAction(ActionRun) != nil && events.FindFirstByAction(EndingActions...) == nil {
+			running[k] = events
+		}
+	}
+	keys := running.LeafTests()
+	now := time.Now()
+	for _, k := range keys {
+		ts.Append(Event{
+			Time:    now,
+			Action:  ActionPanic,
+			Module:  k.Module,
+			Package: k.Package,
+			Test:    k.Test,
+			Variant: k.Variant,
+			Output:  crashNote,
+		})
+	}
+	return keys
+}
+
+// isTraceLine reports if a line of output is part of a goroutine dump.
+func isTraceLine(line string) bool {
+	line = strings.TrimRight(line, "\n")
+	return line == "" ||
+		strings.HasPrefix(line, "\t") ||
+		strings.HasPrefix(line, "goroutine ") ||
+		strings.HasPrefix(line, "created by ") ||
+		strings.HasPrefix(line, "exit status ") ||
+		strings.HasSuffix(line, ")") ||
+		strings.HasSuffix(line, "...")
+}
+
+// panicTrace colors a condensed trace with the first user frame highlighted.
+func panicTrace(trace string) string {
+	lines := strings.Split(strings.TrimSuffix(trace, "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "→ ") {
+			lines[i] = failColorBold(line)
+		} else {
+			lines[i] = failColor(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"os/exec"
+)
+
+// setStop kills cmd when it is cancelled, interrupts and process groups are
+// not supported on this platform so only the go command itself is stopped.
+func setStop(cmd *exec.Cmd, group bool) {
+	cmd.WaitDelay = stopGrace
+}
+
+// killProcessGroup is not supported on this platform.
+func killProcessGroup(cmd *exec.Cmd) {}
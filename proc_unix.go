@@ -0,0 +1,39 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setStop makes cancelling cmd interrupt it, it is killed when it has not
+// exited after stopGrace. With group cmd is started in its own process group
+// and the test binaries started by go test are interrupted and killed with
+// it.
+func setStop(cmd *exec.Cmd, group bool) {
+	if group {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	cmd.Cancel = func() error {
+		return signalProcess(cmd, group, syscall.SIGINT)
+	}
+	cmd.WaitDelay = stopGrace
+}
+
+// killProcessGroup kills what is left of the process group of cmd after go
+// test exited.
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = signalProcess(cmd, true, syscall.SIGKILL)
+}
+
+// signalProcess sends sig to cmd or to every process in its group.
+func signalProcess(cmd *exec.Cmd, group bool, sig syscall.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if !group {
+		return cmd.Process.Signal(sig)
+	}
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// GoTest is a single go test -json invocation.
+type GoTest struct {
+	Dir     string   // working directory, empty for the current one
+	Module  string   // module path, only set when testing several modules
+	Variant string   // matrix cell label, only set when testing a matrix
+	Args    []string // arguments after go test -json
+	Env     []string // added to the environment
+
+	// Exec is the command and its arguments that run instead of go test
+	// when set, it must print test2json events. Args are still used to
+	// describe the run.
+	Exec []string
+
+	// ProcessGroup runs go test in its own process group so that stopping
+	// it also stops the test binaries it started.
+	ProcessGroup bool
+
+	mu  sync.Mutex
+	cmd *exec.Cmd // set while running
+}
+
+// stopGrace is the time go test and its test binaries get to exit after they
+// are interrupted, so that TestMain teardown and t.Cleanup can run, before
+// they are killed.
+const stopGrace = 10 * time.Second
+
+// Run runs go test and sends the decoded events until the output ends.
+func (gt *GoTest) Run(ctx context.Context, bin string, events chan<- Event) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	args := []string{"test", "-json"}
+	args = append(args, gt.Args...)
+	if gt.Exec != nil {
+		bin, args = gt.Exec[0], gt.Exec[1:]
+	}
+	log.Println("args", args, "dir", gt.Dir)
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Dir = gt.Dir
+	cmd.Stderr = os.Stderr
+	setStop(cmd, gt.ProcessGroup)
+	if len(gt.Env) > 0 {
+		cmd.Env = append(os.Environ(), gt.Env...)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+
+	if err := cmd.Start(); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	gt.mu.Lock()
+	gt.cmd = cmd
+	gt.mu.Unlock()
+	defer func() {
+		gt.mu.Lock()
+		gt.cmd = nil
+		gt.mu.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+scan:
+	for scanner.Scan() {
+		var e Event
+		log.Println("LINE:", scanner.Text())
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Println("scanner error", err)
+			continue scan
+		}
+		e.Module = gt.Module
+		e.Variant = gt.Variant
+		e.GoTest = gt
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			break scan
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Println("error reading standard input:", err)
+	}
+	go stdout.Close()
+
+	go func() {
+		time.Sleep(2 * time.Second)
+		cancel()
+	}()
+	cmdErr := cmd.Wait()
+	if gt.ProcessGroup && ctx.Err() != nil {
+		killProcessGroup(cmd)
+	}
+	var ee *exec.ExitError
+	if cmdErr != nil && errors.As(cmdErr, &ee) {
+		if ee.Exited() {
+			return ExitError(ee.ExitCode())
+		}
+	}
+	return nil
+}
+
+// SignalTestBinary sends sig to the test binary go test is running for pkg,
+// go test itself and the other test binaries are left alone.
+func (gt *GoTest) SignalTestBinary(bin, pkg string, sig syscall.Signal) error {
+	var goPid int
+	gt.mu.Lock()
+	if gt.cmd != nil && gt.cmd.Process != nil {
+		goPid = gt.cmd.Process.Pid
+	}
+	gt.mu.Unlock()
+	if goPid == 0 {
+		return errors.New("not running")
+	}
+	dir := PackageDir(bin, gt, pkg)
+	if dir == "" {
+		return fmt.Errorf("%s: package directory not found", pkg)
+	}
+	pid, err := testBinaryPid(goPid, dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pkg, err)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
+
+func (gt *GoTest) String() string {
+	s := "go test " + strings.Join(gt.Args, " ")
+	if len(gt.Env) > 0 {
+		s = strings.Join(gt.Env, " ") + " " + s
+	}
+	if gt.Dir != "" {
+		s = "(cd " + gt.Dir + " && " + s + ")"
+	}
+	return s
+}
+
+// ReproCommand returns a shell command that tests pkg again with the original
+// flags that change how tests behave. The extra flags are added last and
+// replace original flags with the same name.
+func (gt *GoTest) ReproCommand(pkg string, extra ...string) string {
+	var ta TestArgs
+	if gt != nil {
+		ta = ParseTestArgs(gt.Args)
+	}
+	var override []string
+	for _, flag := range extra {
+		name, _, _ := strings.Cut(strings.TrimLeft(flag, "-"), "=")
+		override = append(override, name)
+	}
+	var parts []string
+	if gt != nil && gt.Dir != "" {
+		parts = append(parts, "cd", shellQuote(gt.Dir), "&&")
+	}
+	if gt != nil {
+		for _, env := range gt.Env {
+			parts = append(parts, shellQuote(env))
+		}
+	}
+	parts = append(parts, "go", "test")
+	for _, flag := range append(ta.ReproFlags(override...), extra...) {
+		parts = append(parts, shellQuote(flag))
+	}
+	parts = append(parts, shellQuote(pkg))
+	return strings.Join(parts, " ")
+}
+
+// runGoTests runs up to jobs of the go tests at the same time, all events are
+// sent to the same channel which is closed when every run has ended. The
+// first error in the order of gts is returned.
+func runGoTests(ctx context.Context, bin string, gts []*GoTest, jobs int, events chan<- Event) error {
+	defer close(events)
+	if jobs < 1 {
+		jobs = 1
+	}
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, jobs)
+		errs = make([]error, len(gts))
+	)
+loop:
+	for i, gt := range gts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break loop
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = gt.Run(ctx, bin, events)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
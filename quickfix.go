@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Quickfix returns the failure locations of failed tests, the compiler errors
+// of packages that failed to build and the user frame of panics as
+// path:line:col: message lines that vim and emacs can load.
+func (ts TestStorage) Quickfix(bin string) []string {
+	var lines []string
+	add := func(loc Location, message string) {
+		lines = append(lines, fmt.Sprintf("%s:%d:%d: %s", ShortPath(loc.File, ""), loc.Line, max(loc.Col, 1), message))
+	}
+	output := ts.OutputByPackage()
+	for _, key := range ts.OrderedKeys() {
+		events := ts[key]
+		switch events.Status() {
+		case StatusFail, StatusBuildFail, StatusTimeout, StatusPanic:
+		default:
+			continue
+		}
+		var prefix string
+		if key.Test != "" {
+			prefix = key.Test + ": "
+		}
+		for _, loc := range events.FailureLocations(bin) {
+			add(loc, prefix+loc.Message)
+		}
+		if events.FindFirstByAction(ActionPanic) != nil {
+			pkgKey := key
+			pkgKey.Test = ""
+			p, _ := ParsePanic(output[pkgKey])
+			if f, ok := p.UserFrame(); ok && len(p.Message) > 0 {
+				add(Location{File: f.File, Line: f.Line}, prefix+p.Message[0])
+			}
+		}
+	}
+	return lines
+}
+
+// WriteQuickfix writes the quickfix lines to path.
+func (ts TestStorage) WriteQuickfix(bin string, path string) error {
+	var sb strings.Builder
+	for _, line := range ts.Quickfix(bin) {
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0o644)
+}
+
+// FailureLocations returns the locations in the output with absolute paths,
+// testify failures are reported at their assertion with their message.
+func (es Events) FailureLocations(bin string) []Location {
+	// Only error output is used when go marks it, otherwise t.Log lines
+	// look the same as t.Error lines.
+	hasTypes := es.HasOutputTypes()
+	var output Events
+	for _, e := range es {
+		if e.Action == ActionOutput && (!hasTypes || e.IsError()) {
+			output = append(output, e)
+		}
+	}
+	var locs []Location
+	for len(output) > 0 {
+		lines := make([]string, len(output))
+		for i, e := range output {
+			lines[i] = strings.TrimSuffix(e.Output, "\n")
+		}
+		f, start, end, ok := ParseTestify(lines)
+		if !ok {
+			start, end = len(output), len(output)
+		}
+		for i, e := range output[:start] {
+			_, loc, ok := ParseLocation(lines[i])
+			if !ok {
+				continue
+			}
+			if dir := e.LocationDir(bin); dir != "" {
+				locs = append(locs, loc.Resolve(dir))
+			}
+		}
+		if loc, ok := f.Location(); ok {
+			if dir := output[start].LocationDir(bin); dir != "" {
+				loc = loc.Resolve(dir)
+			}
+			locs = append(locs, loc)
+		}
+		output = output[end:]
+	}
+	return locs
+}
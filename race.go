@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RaceStack is one stack of a data race report, either a memory access or
+// the creation site of a goroutine involved in the race.
+type RaceStack struct {
+	Kind   string // "read", "previous write", "goroutine created", ...
+	Frames []Frame
+}
+
+// Race is a report of the race detector.
+type Race struct {
+	Accesses []RaceStack
+	Created  []RaceStack
+}
+
+// RaceReport is a race and the results whose output contained it.
+type RaceReport struct {
+	Race
+	Keys []Key
+}
+
+// ParseRaces finds every "WARNING: DATA RACE" block in the output of a test.
+func ParseRaces(lines []string) []Race {
+	var (
+		races []Race
+		race  *Race
+		stack *RaceStack
+	)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		switch {
+		case line == "WARNING: DATA RACE":
+			races = append(races, Race{})
+			race = &races[len(races)-1]
+			stack = nil
+		case race == nil:
+		case line == "==================":
+			race, stack = nil, nil
+		case line == "":
+			stack = nil
+		case !strings.HasPrefix(line, " ") && strings.HasSuffix(line, ":"):
+			kind, _, _ := strings.Cut(line, " at ")
+			if strings.HasPrefix(kind, "Goroutine ") {
+				race.Created = append(race.Created, RaceStack{Kind: "goroutine created"})
+				stack = &race.Created[len(race.Created)-1]
+			} else {
+				race.Accesses = append(race.Accesses, RaceStack{Kind: strings.ToLower(kind)})
+				stack = &race.Accesses[len(race.Accesses)-1]
+			}
+		case stack != nil && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "      "):
+			stack.Frames = append(stack.Frames, parseFrame(line, lines[i+1]))
+			i++
+		}
+	}
+	return races
+}
+
+// UserFrame returns the innermost frame outside of the runtime and testing
+// packages.
+func (rs RaceStack) UserFrame() (Frame, bool) {
+	for _, f := range rs.Frames {
+		if !f.IsRuntime() {
+			return f, true
+		}
+	}
+	return Frame{}, false
+}
+
+// Signature identifies a race by its stacks without addresses and goroutine
+// ids so that the same race found by different tests compares equal. The
+// stacks are sorted since either access of a race can be reported first.
+func (r Race) Signature() string {
+	var sb strings.Builder
+	for _, stacks := range [][]RaceStack{r.Accesses, r.Created} {
+		var sigs []string
+		for _, s := range stacks {
+			var sig strings.Builder
+			sig.WriteString(strings.TrimPrefix(s.Kind, "previous "))
+			sig.WriteString("\n")
+			for _, f := range s.Frames {
+				fmt.Fprintf(&sig, "%s %s:%d\n", f.Func, f.File, f.Line)
+			}
+			sigs = append(sigs, sig.String())
+		}
+		sort.Strings(sigs)
+		for _, sig := range sigs {
+			sb.WriteString(sig)
+		}
+	}
+	return sb.String()
+}
+
+// Lines returns one line per stack with its first frame in user code.
+func (r Race) Lines() []string {
+	var lines []string
+	for _, stacks := range [][]RaceStack{r.Accesses, r.Created} {
+		for _, s := range stacks {
+			line := s.Kind + " at"
+			if f, ok := s.UserFrame(); ok {
+				line += fmt.Sprintf(" %s  %s:%d", f.ShortFunc(), ShortPath(f.File, ""), f.Line)
+			} else {
+				line += " unknown location"
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Races returns the data races found in the output of all results, reports
+// with the same signature are merged.
+func (ts TestStorage) Races() []RaceReport {
+	var (
+		reports []RaceReport
+		index   = make(map[string]int)
+	)
+	for _, key := range ts.OrderedKeys() {
+		var lines []string
+		for _, e := range ts[key] {
+			if e.Action == ActionOutput {
+				lines = append(lines, strings.TrimSuffix(e.Output, "\n"))
+			}
+		}
+		for _, race := range ParseRaces(lines) {
+			sig := race.Signature()
+			i, ok := index[sig]
+			if !ok {
+				i = len(reports)
+				index[sig] = i
+				reports = append(reports, RaceReport{Race: race})
+			}
+			if keys := reports[i].Keys; len(keys) == 0 || keys[len(keys)-1] != key {
+				reports[i].Keys = append(reports[i].Keys, key)
+			}
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return len(reports[i].Keys) > len(reports[j].Keys)
+	})
+	return reports
+}
+
+// PrintRaceSummary prints every distinct data race with the results that
+// triggered it.
+func (ts TestStorage) PrintRaceSummary() {
+	reports := ts.Races()
+	if len(reports) == 0 {
+		return
+	}
+	hr := failColor("════════════")
+	fmt.Println(hr, failColorBold("RACES"), hr)
+	for i, r := range reports {
+		prefix := failColorBold(fmt.Sprintf("  RACE %d", i+1))
+		for j, line := range r.Lines() {
+			if j > 0 {
+				prefix = strings.Repeat(" ", len(fmt.Sprintf("  RACE %d", i+1)))
+			}
+			fmt.Println(prefix + "  " + failColor(line))
+		}
+		for _, key := range r.Keys {
+			name := packageColor(key.Package)
+			if key.Test != "" {
+				name += "." + testColor(key.Test)
+			}
+			if key.Variant != "" {
+				name += "  " + variantColor("["+key.Variant+"]")
+			}
+			fmt.Println("       in " + name)
+		}
+	}
+}
+
+// HasRace reports if the output contains a data race report.
+func (es Events) HasRace() bool {
+	for _, e := range es {
+		if e.Action == ActionOutput && e.Output == "WARNING: DATA RACE\n" {
+			return true
+		}
+	}
+	return false
+}
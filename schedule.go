@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// scheduleTests splits every go test run into one run per package, ordered so
+// that the packages that took the longest last time start first. Packages
+// without history are assumed to be slow.
+func scheduleTests(ctx context.Context, flags Flags, gts []*GoTest, history *History) ([]*GoTest, error) {
+	type scheduled struct {
+		gt      *GoTest
+		elapsed float64
+	}
+	var all []scheduled
+	for _, gt := range gts {
+		ta := ParseTestArgs(gt.Args)
+		listArgs := []string{}
+		if tags, ok := ta.Lookup("tags"); ok {
+			listArgs = append(listArgs, "-tags="+tags)
+		}
+		pkgs, err := goList(ctx, flags.Bin, gt.Dir, append(listArgs, ta.Patterns()...)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pkgs {
+			elapsed, ok := history.Elapsed(p.ImportPath)
+			if !ok {
+				elapsed = math.Inf(1)
+			}
+			all = append(all, scheduled{
+				gt: &GoTest{
+					Dir:     gt.Dir,
+					Module:  gt.Module,
+					Variant: gt.Variant,
+					Args:    ta.WithPackages(p.ImportPath).Argv(),
+					Env:     gt.Env,
+				},
+				elapsed: elapsed,
+			})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].elapsed > all[j].elapsed
+	})
+	var result []*GoTest
+	for _, s := range all {
+		result = append(result, s.gt)
+	}
+	return result, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// failureCounter counts distinct failures. A test that fails because one of
+// its subtests failed and a package that fails because of its tests are not
+// counted again.
+type failureCounter struct {
+	failed   map[Key]bool
+	distinct int
+}
+
+// Add returns true when e is a new distinct failure.
+func (fc *failureCounter) Add(e Event) bool {
+	if e.Action != ActionFail && e.Action != ActionBuildFail {
+		return false
+	}
+	if e.Action == ActionFail && e.FailedBuild != "" {
+		return false
+	}
+	if fc.failed == nil {
+		fc.failed = make(map[Key]bool)
+	}
+	key := e.Key()
+	if fc.failed[key] {
+		return false
+	}
+	fc.failed[key] = true
+	for k := range fc.failed {
+		if k.Module != key.Module || k.Variant != key.Variant || k == key {
+			continue
+		}
+		samePackage := k.Package == key.Package || strings.HasPrefix(k.Package, key.Package+" ")
+		if samePackage && (key.Test == "" || strings.HasPrefix(k.Test, key.Test+"/")) {
+			return false
+		}
+	}
+	fc.distinct++
+	return true
+}
+
+// Count returns the number of distinct failures seen.
+func (fc *failureCounter) Count() int {
+	return fc.distinct
+}
+
+// listPackageKeys returns the keys of the packages the go test runs would
+// test.
+func listPackageKeys(ctx context.Context, bin string, gts []*GoTest) ([]Key, error) {
+	var keys []Key
+	for _, gt := range gts {
+		ta := ParseTestArgs(gt.Args)
+		var listArgs []string
+		if tags, ok := ta.Lookup("tags"); ok {
+			listArgs = append(listArgs, "-tags="+tags)
+		}
+		pkgs, err := goList(ctx, bin, gt.Dir, append(listArgs, ta.Patterns()...)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pkgs {
+			keys = append(keys, Key{
+				Module:  gt.Module,
+				Package: p.ImportPath,
+				Variant: gt.Variant,
+			})
+		}
+	}
+	return keys, nil
+}
+
+// MarkStopped adds a note with the reason to every result that did not finish
+// and to the packages that never started. It returns the number of packages
+// that were not run and cut short.
+func (ts TestStorage) MarkStopped(reason string, packages []Key) (notRun int, cutShort int) {
+	for _, key := range ts.OrderedKeys() {
+		if ts[key].FindFirstByAction(EndingActions...) != nil {
+			continue
+		}
+		ts.Note(key, "cut short, "+reason)
+		if key.Test == "" {
+			cutShort++
+		}
+	}
+	for _, key := range packages {
+		if _, ok := ts[key]; ok {
+			continue
+		}
+		ts.Note(key, "not run, "+reason)
+		notRun++
+	}
+	return notRun, cutShort
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stressCounter keeps the live totals of a stress run.
+type stressCounter struct {
+	started atomic.Int64
+	runs    atomic.Int64
+	passes  atomic.Int64
+	fails   atomic.Int64
+}
+
+func (sc *stressCounter) Line(elapsed time.Duration) string {
+	runs := sc.runs.Load()
+	rate := float64(runs) / elapsed.Seconds()
+	return fmt.Sprintf("%s: %d runs, %s, %s, %.1f runs/s",
+		elapsed.Round(time.Second), runs,
+		passColor(fmt.Sprintf("%d passes", sc.passes.Load())),
+		failColor(fmt.Sprintf("%d failures", sc.fails.Load())),
+		rate)
+}
+
+// stressRun is the result of one iteration.
+type stressRun struct {
+	tests  TestStorage
+	events Events
+	err    error
+}
+
+func (sr stressRun) Failed() bool {
+	if sr.err != nil {
+		return true
+	}
+	return len(sr.tests.FindByAction(ActionFail)) > 0 ||
+		len(sr.tests.FindByAction(ActionBuildFail)) > 0
+}
+
+// stress runs the tests over and over in parallel processes until one of
+// them fails or the time or iteration budget is used up. The test binaries
+// are built once and then run directly, like golang.org/x/tools/cmd/stress.
+func stress(ctx context.Context, flags Flags, argv []string) error {
+	ta := ParseTestArgs(argv)
+	gt := &GoTest{Args: ta.Argv()}
+
+	dir, err := os.MkdirTemp("", "tgo-stress-bin-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	gts, build, err := stressTests(ctx, flags.Bin, ta, dir)
+	if err != nil {
+		return err
+	}
+	if build.Failed() {
+		return build.Print(flags)
+	}
+	if len(gts) == 0 {
+		return errors.New("no test files to stress")
+	}
+
+	if flags.StressTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flags.StressTime)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parallel := flags.StressP
+	if parallel < 1 {
+		parallel = runtime.NumCPU()
+	}
+
+	var (
+		counter stressCounter
+		wg      sync.WaitGroup
+		failed  = make(chan stressRun, parallel)
+	)
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if n := counter.started.Add(1); flags.StressCount > 0 && n > int64(flags.StressCount) {
+					return
+				}
+				sr := stressOnce(ctx, flags.Bin, gts)
+				if ctx.Err() != nil {
+					return
+				}
+				counter.runs.Add(1)
+				if !sr.Failed() {
+					counter.passes.Add(1)
+					continue
+				}
+				counter.fails.Add(1)
+				select {
+				case failed <- sr:
+				default:
+				}
+				cancel()
+				return
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	fmt.Println("***** stress", gt.String())
+	t0 := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			fmt.Print("\r" + counter.Line(time.Since(t0)))
+		case <-done:
+			break loop
+		}
+	}
+	fmt.Println("\r" + counter.Line(time.Since(t0)))
+
+	select {
+	case sr := <-failed:
+		return sr.Print(flags)
+	default:
+	}
+	return nil
+}
+
+// stressTests builds the test binary of every package matched by ta into
+// dir and returns a run per binary that executes it through test2json in
+// the package directory, like go test does. A failed build is returned as a
+// failed stressRun.
+func stressTests(ctx context.Context, bin string, ta TestArgs, dir string) ([]*GoTest, stressRun, error) {
+	buildFlags, goTestFlags, binaryFlags := ta.SplitFlags()
+	pkgs, err := goList(ctx, bin, "", append(slices.Clip(buildFlags), ta.Patterns()...)...)
+	if err != nil {
+		return nil, stressRun{}, err
+	}
+	// -vet applies to go test -c too, the others are about running the
+	// binary or replaced by tgo
+	compileFlags := slices.Clip(buildFlags)
+	for _, f := range goTestFlags {
+		if strings.HasPrefix(f, "-vet=") {
+			compileFlags = append(compileFlags, f)
+		}
+	}
+	var builds []*GoTest
+	for i, p := range pkgs {
+		out := filepath.Join(dir, strconv.Itoa(i)) + string(filepath.Separator)
+		args := append([]string{"-c", "-o", out}, compileFlags...)
+		builds = append(builds, &GoTest{Args: append(args, p.ImportPath)})
+	}
+	build := stressOnce(ctx, bin, builds)
+	if build.Failed() {
+		return nil, build, nil
+	}
+	test2json, err := exec.CommandContext(ctx, bin, "tool", "-n", "test2json").Output()
+	if err != nil {
+		return nil, stressRun{}, fmt.Errorf("finding test2json: %w", err)
+	}
+
+	// go test sets these for the test binaries it runs
+	binaryFlags = append([]string{"-test.paniconexit0"}, binaryFlags...)
+	if _, ok := ta.Lookup("timeout"); !ok {
+		binaryFlags = append(binaryFlags, "-test.timeout=10m0s")
+	}
+	binaryFlags = append(binaryFlags, "-test.v=test2json")
+	var args []string
+	if len(ta.Args) > 0 {
+		args = ta.Args[1:]
+	}
+	// like go test, run the binaries with the -exec program
+	var execCmd []string
+	if v, ok := ta.Lookup("exec"); ok {
+		execCmd = strings.Fields(v)
+	}
+	var gts []*GoTest
+	for i, p := range pkgs {
+		exe := filepath.Join(dir, strconv.Itoa(i), path.Base(p.ImportPath)+".test")
+		if runtime.GOOS == "windows" {
+			exe += ".exe"
+		}
+		if _, err := os.Stat(exe); err != nil {
+			// no test files
+			continue
+		}
+		cmd := []string{strings.TrimSpace(string(test2json)), "-p", p.ImportPath, "-t"}
+		cmd = append(append(cmd, execCmd...), exe)
+		cmd = append(append(cmd, binaryFlags...), args...)
+		gts = append(gts, &GoTest{
+			Dir:          p.Dir,
+			Args:         ta.WithPackages(p.ImportPath).Argv(),
+			Exec:         cmd,
+			ProcessGroup: true,
+		})
+	}
+	return gts, build, nil
+}
+
+// stressOnce runs the go tests once and collects all of their events.
+func stressOnce(ctx context.Context, bin string, gts []*GoTest) stressRun {
+	sr := stressRun{tests: make(TestStorage)}
+	events := make(chan Event)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- runGoTests(ctx, bin, gts, runtime.NumCPU(), events)
+	}()
+	for e := range events {
+		sr.events = append(sr.events, e)
+		sr.tests.Append(e)
+	}
+	sr.err = <-errc
+	return sr
+}
+
+// Print saves the full output of a failed iteration to a file and prints the
+// failed results.
+func (sr stressRun) Print(flags Flags) error {
+	f, err := os.CreateTemp("", "tgo-stress-*.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range sr.events {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Println("")
+	failed := sr.tests.FindByAction(ActionFail).Union(sr.tests.FindByAction(ActionBuildFail))
+	for _, key := range failed.OrderedKeys() {
+		failed[key].PrintDetail(flags)
+	}
+	fmt.Println(failColorBold("failing run saved to " + f.Name()))
+
+	var ee ExitError
+	if errors.As(sr.err, &ee) {
+		return ee
+	}
+	return ExitError(1)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// JSONSummary is the machine readable summary of a run written by
+// -json-summary.
+type JSONSummary struct {
+	Time    time.Time      `json:"time"`
+	Elapsed float64        `json:"elapsed"` // seconds
+	Counts  map[Status]int `json:"counts"`
+	Results []JSONResult   `json:"results"`
+
+	Benchmarks []Benchmark `json:"benchmarks,omitempty"`
+}
+
+// JSONResult is the result of one package or test.
+type JSONResult struct {
+	Module  string            `json:"module,omitempty"`
+	Package string            `json:"package"`
+	Test    string            `json:"test,omitempty"`
+	Variant string            `json:"variant,omitempty"`
+	Status  Status            `json:"status"`
+	Elapsed float64           `json:"elapsed"` // seconds
+	Attrs   map[string]string `json:"attrs,omitempty"`
+	Notes   []string          `json:"notes,omitempty"`
+}
+
+// JSONSummary returns the summary of all results, counts are of tests only
+// like the status line.
+func (ts TestStorage) JSONSummary(t0 time.Time) JSONSummary {
+	s := JSONSummary{
+		Time:    t0,
+		Elapsed: time.Since(t0).Seconds(),
+		Counts:  make(map[Status]int),
+		Results: []JSONResult{},
+	}
+	for _, key := range ts.OrderedKeys() {
+		events := ts[key]
+		r := JSONResult{
+			Module:  key.Module,
+			Package: key.Package,
+			Test:    key.Test,
+			Variant: key.Variant,
+			Status:  events.Status(),
+			Attrs:   events.Attrs().Map(),
+			Notes:   events.Notes(),
+		}
+		if e := events.FindFirstByAction(EndingActions...); e != nil {
+			r.Elapsed = e.Elapsed
+		}
+		if key.Test != "" {
+			s.Counts[r.Status]++
+		}
+		s.Results = append(s.Results, r)
+	}
+	s.Benchmarks = ts.Benchmarks()
+	return s
+}
+
+// WriteJSONSummary writes the summary of all results to path.
+func (ts TestStorage) WriteJSONSummary(path string, t0 time.Time) error {
+	data, err := json.MarshalIndent(ts.JSONSummary(t0), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,15 @@
+package orderdep
+
+import "testing"
+
+var polluted bool
+
+func TestPollute(t *testing.T) {
+	polluted = true
+}
+
+func TestClean(t *testing.T) {
+	if polluted {
+		t.Fatal("state left behind by TestPollute")
+	}
+}
@@ -0,0 +1,10 @@
+package panic
+
+import "testing"
+
+func TestPanic(t *testing.T) {
+	t.Run("sub", func(t *testing.T) {
+		var m map[string]int
+		m["a"] = 1
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// TestifyFailure is a failure message printed by a testify assertion.
+type TestifyFailure struct {
+	Trace    []string // file:line of the assertion and its callers
+	Error    []string // failure message including expected and actual values
+	Diff     []string // the expected/actual diff of the failure, if any
+	Test     string
+	Messages []string
+}
+
+var (
+	testifyFieldRe = regexp.MustCompile(`^\s*\t(Error Trace|Error|Test|Messages):\s*\t(.*)$`)
+	testifyContRe  = regexp.MustCompile(`^\s*\t *\t(.*)$`)
+)
+
+// ParseTestify finds the first testify failure block in lines and returns it
+// with the range of lines it was printed on, including the file:line: line
+// of t.Errorf before it.
+func ParseTestify(lines []string) (f TestifyFailure, start, end int, ok bool) {
+	start = -1
+	for i, line := range lines {
+		if m := testifyFieldRe.FindStringSubmatch(line); m != nil && m[1] != "Messages" && m[1] != "Test" {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return f, 0, 0, false
+	}
+
+	var field *[]string
+	end = start
+	for ; end < len(lines); end++ {
+		line := lines[end]
+		if m := testifyFieldRe.FindStringSubmatch(line); m != nil {
+			switch m[1] {
+			case "Error Trace":
+				field = &f.Trace
+			case "Error":
+				field = &f.Error
+			case "Test":
+				f.Test = strings.TrimSpace(m[2])
+				field = nil
+				continue
+			case "Messages":
+				field = &f.Messages
+			}
+			line = m[2]
+		} else if m := testifyContRe.FindStringSubmatch(line); m != nil && field != nil {
+			line = m[1]
+		} else {
+			break
+		}
+		text := strings.TrimSpace(line)
+		switch {
+		case field == &f.Error && text == "Diff:":
+			field = &f.Diff
+		case field == &f.Diff:
+			// only the column prefix is stripped so that context lines
+			// keep their leading space and values their indentation.
+			if line != "" {
+				*field = append(*field, line)
+			}
+		case text == "":
+		case field == &f.Trace:
+			*field = append(*field, text)
+		default:
+			*field = append(*field, strings.TrimRight(line, " "))
+		}
+	}
+	if start > 0 {
+		if _, loc, ok := ParseLocation(lines[start-1]); ok && loc.Message == "" {
+			start--
+		}
+	}
+	return f, start, end, true
+}
+
+// Location returns where the failed assertion was called.
+func (f TestifyFailure) Location() (Location, bool) {
+	if len(f.Trace) == 0 {
+		return Location{}, false
+	}
+	_, loc, ok := ParseLocation(f.Trace[0] + ":")
+	if !ok {
+		return Location{}, false
+	}
+	loc.Message = f.Message()
+	return loc, true
+}
+
+// Message returns the first line of the error and the messages of the
+// assertion.
+func (f TestifyFailure) Message() string {
+	var parts []string
+	if len(f.Error) > 0 {
+		parts = append(parts, strings.TrimSuffix(f.Error[0], ":"))
+	}
+	parts = append(parts, f.Messages...)
+	return strings.Join(parts, ": ")
+}
+
+// Lines renders the failure compactly as a location line with the error
+// message followed by the rest of the error and the diff.
+func (f TestifyFailure) Lines() []string {
+	var lines []string
+	first := "    "
+	if len(f.Trace) > 0 {
+		first += f.Trace[0] + ": "
+	}
+	if len(f.Error) > 0 {
+		first += failColorBold(f.Error[0])
+	}
+	lines = append(lines, first)
+	if len(f.Error) > 1 {
+		for _, line := range f.Error[1:] {
+			lines = append(lines, "        "+line)
+		}
+	}
+	for _, line := range f.Diff {
+		lines = append(lines, "        "+line)
+	}
+	for _, line := range f.Messages {
+		lines = append(lines, "        messages: "+line)
+	}
+	return lines
+}
+
+// CompactTestify replaces every testify failure block in the output events
+// with its compact rendering.
+func (es Events) CompactTestify() Events {
+	var result Events
+	for {
+		lines := make([]string, len(es))
+		for i, e := range es {
+			lines[i] = strings.TrimSuffix(e.Output, "\n")
+		}
+		f, start, end, ok := ParseTestify(lines)
+		if !ok {
+			return append(result, es...)
+		}
+		result = append(result, es[:start]...)
+		for _, line := range f.Lines() {
+			e := es[start]
+			e.Output = line + "\n"
+			result = append(result, e)
+		}
+		es = es[end:]
+	}
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// testBinaryPid returns the pid of the test binary that the go command with
+// pid goPid is running for the package in dir. go test runs test binaries as
+// its own children in the directory of their package.
+func testBinaryPid(goPid int, dir string) (int, error) {
+	if d, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = d
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		proc := filepath.Join("/proc", entry.Name())
+		stat, err := os.ReadFile(filepath.Join(proc, "stat"))
+		if err != nil {
+			continue
+		}
+		// the command name in parentheses may contain spaces, the state and
+		// the parent pid follow it
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 2 || fields[1] != strconv.Itoa(goPid) {
+			continue
+		}
+		if cwd, err := os.Readlink(filepath.Join(proc, "cwd")); err != nil || cwd != dir {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join(proc, "cmdline"))
+		if err != nil {
+			continue
+		}
+		if name, _, _ := bytes.Cut(cmdline, []byte{0}); strings.HasSuffix(string(name), ".test") {
+			return pid, nil
+		}
+	}
+	return 0, errors.New("test binary not found")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// testBinaryPid is not supported on this platform.
+func testBinaryPid(goPid int, dir string) (int, error) {
+	return 0, errors.New("finding the test binary is only supported on linux")
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -12,8 +10,8 @@ import (
 	"log"
 	"maps"
 	"os"
-	"os/exec"
 	"os/signal"
+	"runtime"
 	"runtime/debug"
 	"slices"
 	"sort"
@@ -51,14 +49,28 @@ var (
 	ActionFinish      = Action("finish")
 	ActionBuildOutput = Action("build-output")
 	ActionBuildFail   = Action("build-fail")
+	ActionAttr        = Action("attr")
+
+	// ActionNote is never produced by go test, tgo adds it to explain a
+	// result, for example why a package has no result.
+	ActionNote = Action("note")
+
+	// ActionTimeout is never produced by go test, tgo adds it to the tests
+	// that were running when the test binary timed out.
+	ActionTimeout = Action("timeout")
+
+	// ActionPanic is never produced by go test, tgo adds it to the test whose
+	// panic or fatal error crashed the test binary.
+	ActionPanic = Action("panic")
 
 	AllActions = Actions{
 		ActionRun, ActionPause, ActionCont, ActionPass,
 		ActionBench, ActionFail, ActionOutput, ActionSkip,
 		ActionStart, ActionFinish, ActionBuildOutput, ActionBuildFail,
+		ActionAttr,
 	}
 
-	EndingActions = Actions{ActionFail, ActionSkip, ActionPass, ActionBench, ActionBuildFail}
+	EndingActions = Actions{ActionFail, ActionSkip, ActionPass, ActionBench, ActionBuildFail, ActionTimeout, ActionPanic}
 )
 
 var (
@@ -67,6 +79,8 @@ var (
 	StatusSkip      = Status(ActionSkip)
 	StatusBench     = Status(ActionBench)
 	StatusBuildFail = Status(ActionBuildFail)
+	StatusTimeout   = Status(ActionTimeout)
+	StatusPanic     = Status(ActionPanic)
 	StatusNone      = Status("none")
 
 	AllStatuses = Statuses{
@@ -76,11 +90,15 @@ var (
 		StatusNone,
 		StatusFail,
 		StatusBuildFail,
+		StatusTimeout,
+		StatusPanic,
 	}
 	DefaultStatuses = Statuses{
 		StatusNone,
 		StatusFail,
 		StatusBuildFail,
+		StatusTimeout,
+		StatusPanic,
 		StatusBench,
 	}
 
@@ -91,6 +109,8 @@ var (
 		StatusSkip:      "SKIP",
 		StatusBench:     "BENCH",
 		StatusBuildFail: "BUILD FAIL",
+		StatusTimeout:   "TIMEOUT",
+		StatusPanic:     "PANIC",
 	}
 )
 
@@ -103,6 +123,10 @@ var (
 	testColorBold = color.New(color.FgMagenta, color.Bold).SprintFunc()
 	timeColor     = color.New(color.FgCyan).SprintFunc()
 	coverColor    = color.New(color.FgBlue).SprintFunc()
+	attrColor     = color.New(color.FgHiCyan).SprintFunc()
+	variantColor  = color.New(color.FgHiBlue).SprintFunc()
+
+	logColor = color.New(color.Faint).SprintFunc()
 
 	failColor     = color.New(color.FgRed).SprintFunc()
 	failColorBold = color.New(color.FgRed, color.Bold).SprintFunc()
@@ -123,6 +147,8 @@ var (
 		StatusSkip:      skipColor,
 		StatusBench:     passColor,
 		StatusBuildFail: failColor,
+		StatusTimeout:   failColor,
+		StatusPanic:     failColor,
 	}
 
 	statusColorsBold = map[Status](func(a ...any) string){
@@ -132,6 +158,8 @@ var (
 		StatusSkip:      skipColorBold,
 		StatusBench:     passColorBold,
 		StatusBuildFail: failColorBold,
+		StatusTimeout:   failColorBold,
+		StatusPanic:     failColorBold,
 	}
 )
 
@@ -145,11 +173,34 @@ type Flags struct {
 	Bin              string
 	All              bool
 	PrintConfig      bool
+	ChangedSince     string
+	Modules          bool
+	ModuleJobs       int
+	Matrix           Matrix
+	Jobs             int
+	History          string
+	StopOnFail       bool
+	MaxFailures      int
+	Stress           bool
+	StressP          int
+	StressTime       time.Duration
+	StressCount      int
+	Isolate          bool
+	HangAfter        time.Duration
+	HangQuit         bool
+	ErrorsOnly       bool
+	EditorURL        string
+	Quickfix         string
+	JSONSummary      string
+	Attr             AttrFilter
+	GroupBy          GroupBy
+	BenchBaseline    string
+	BenchThreshold   float64
 }
 
 func (f *Flags) Register(fs *flag.FlagSet) {
-	f.Results = Statuses{StatusFail, StatusNone, StatusBuildFail}
-	f.Summary = Statuses{StatusFail, StatusNone, StatusBuildFail}
+	f.Results = Statuses{StatusFail, StatusNone, StatusBuildFail, StatusTimeout, StatusPanic}
+	f.Summary = Statuses{StatusFail, StatusNone, StatusBuildFail, StatusTimeout, StatusPanic}
 
 	fs.StringVar(&f.Bin, "bin", "go", "go binary name")
 	fs.Var(&f.Results, "results", "types of results to show")
@@ -159,6 +210,29 @@ func (f *Flags) Register(fs *flag.FlagSet) {
 	fs.StringVar(&f.Config, "config", "", "config file")
 	fs.BoolVar(&f.All, "all", false, "show mostly everything")
 	fs.BoolVar(&f.PrintConfig, "print_config", false, "print config")
+	fs.StringVar(&f.ChangedSince, "changed-since", "", "only test packages affected by changes since git ref")
+	fs.BoolVar(&f.Modules, "modules", false, "test every module in go.work or below the working directory")
+	fs.IntVar(&f.ModuleJobs, "module-jobs", 1, "number of modules to test at the same time")
+	fs.Var(&f.Matrix, "matrix", "run every combination of flag or env:NAME values, name=a,b;name=c,d")
+	fs.IntVar(&f.Jobs, "jobs", 0, "run each package as its own go test, this many at the same time, slowest first when -history is set")
+	fs.StringVar(&f.History, "history", "", "file to remember results between runs in, auto for one in the user cache directory")
+	fs.BoolVar(&f.StopOnFail, "stop-on-fail", false, "stop all tests at the first failure")
+	fs.IntVar(&f.MaxFailures, "max-failures", 0, "stop all tests after this many distinct failures, 0 for no limit")
+	fs.BoolVar(&f.Stress, "stress", false, "run the tests repeatedly until they fail")
+	fs.IntVar(&f.StressP, "stress-p", runtime.NumCPU(), "number of parallel stress runs")
+	fs.DurationVar(&f.StressTime, "stress-time", 0, "stop stressing after this long, 0 for no limit")
+	fs.IntVar(&f.StressCount, "stress-count", 0, "stop stressing after this many runs, 0 for no limit")
+	fs.BoolVar(&f.Isolate, "isolate", false, "run failed tests again one by one to find order dependent failures")
+	fs.DurationVar(&f.HangAfter, "hang-after", 0, "warn about tests running longer than this, 0 to disable")
+	fs.BoolVar(&f.HangQuit, "hang-quit", false, "send SIGQUIT to hanging tests to get a goroutine dump")
+	fs.BoolVar(&f.ErrorsOnly, "errors-only", false, "only show t.Error output of failed tests, needs go 1.25")
+	fs.StringVar(&f.EditorURL, "editor-url", "file://{path}", "url template for links to failure locations, {path}, {line} and {col} are replaced")
+	fs.StringVar(&f.JSONSummary, "json-summary", "", "write a json summary of all results to this file")
+	fs.Var(&f.Attr, "attr", "only list tests with this t.Attr value in summaries and clusters, name=value")
+	fs.Var(&f.GroupBy, "group-by", "group summaries by attr:NAME")
+	fs.StringVar(&f.Quickfix, "quickfix", "", "write failure locations to this file in path:line:col: message format")
+	fs.StringVar(&f.BenchBaseline, "bench-baseline", "", "compare benchmark results with the benchmarks in this -json-summary file")
+	fs.Float64Var(&f.BenchThreshold, "bench-threshold", 0, "fail when a benchmark regresses significantly by more than this percent, 0 to never fail")
 }
 
 func (f *Flags) PrintHelp(w io.Writer) {
@@ -167,6 +241,15 @@ func (f *Flags) PrintHelp(w io.Writer) {
 	}
 
 	fmt.Fprint(w, `
+commands:
+
+  tgo [go test flags] [packages]
+                    run go test and report the results
+  tgo bench-compare <ref> [go test flags] [packages]
+                    run the benchmarks of a git ref, checked out in a temporary
+                    worktree, and of the working tree in alternating rounds,
+                    -count=6 by default, and compare them like TGO_BENCH_BASELINE
+
 settings:
 
   tgo specific settings are controlled using environment variables so it
@@ -179,6 +262,46 @@ settings:
   TGO_RES_HIDE      types of results to hide when empty
   TGO_BIN=go        go binary name
   TGO_PRINT_CONFIG  print config on run
+  TGO_CHANGED_SINCE only test packages affected by changes since a git ref
+  TGO_MODULES=1     test every module in go.work or below the working directory
+  TGO_MODULE_JOBS=1 number of modules to test at the same time
+  TGO_MATRIX        run every combination of go test flag or env:NAME values,
+                    "tags=,integration;race=off,on;env:GOEXPERIMENT=,loopvar"
+  TGO_JOBS=0        run each package as its own go test, this many at the
+                    same time, historically slowest packages first when
+                    TGO_HISTORY is set, go list order otherwise
+  TGO_HISTORY       file to remember package durations for TGO_JOBS and the
+                    output of passing tests in, auto for one per working
+                    directory in the user cache directory, off by default
+  TGO_STOP_ON_FAIL=1 stop all packages at the first test or build failure
+  TGO_MAX_FAILURES=0 stop all packages after this many distinct failures
+  TGO_STRESS=1      run the tests repeatedly in parallel until one run fails
+  TGO_STRESS_P      number of parallel stress runs, defaults to number of CPUs
+  TGO_STRESS_TIME   stop stressing after this duration, eg. 10m
+  TGO_STRESS_COUNT  stop stressing after this many runs
+  TGO_ISOLATE=1     run up to 50 failed tests again, each alone in a fresh
+                    process, to tell real failures from order dependent ones
+  TGO_HANG_AFTER    warn about tests running longer than this, eg. 2m
+  TGO_HANG_QUIT=1   send SIGQUIT to the test binary of a hanging test so its
+                    goroutine dump is added to the test
+  TGO_ERRORS_ONLY=1 only show the t.Error and t.Fatal output of failed tests,
+                    needs go 1.25 or later
+  TGO_EDITOR_URL    url template for links to file.go:NN: failure locations,
+                    eg. "vscode://file/{path}:{line}", empty to disable,
+                    defaults to "file://{path}"
+  TGO_JSON_SUMMARY  write the result, elapsed time and t.Attr attributes of
+                    every package and test to this file as json
+  TGO_ATTR          only list tests with this t.Attr attribute in summaries and
+                    clusters, eg. "owner=team-a", subtests inherit attributes
+  TGO_GROUP_BY      group summaries by the value of a t.Attr attribute,
+                    eg. "attr:owner"
+  TGO_QUICKFIX      write failure locations, compiler errors and panics to this
+                    file as path:line:col: message for :cfile or compilation-mode
+  TGO_BENCH_BASELINE compare benchmark results with the benchmarks of an earlier
+                    TGO_JSON_SUMMARY file using the Mann-Whitney U test
+  TGO_BENCH_THRESHOLD=0 fail the run or bench-compare when a metric of a
+                    benchmark got significantly worse by more than this
+                    percent, 0 to never fail
 
 `)
 
@@ -196,8 +319,10 @@ TGO config:
   TGO_RESULTS: %s
   TGO_SUMMARY: %s
   TGO_RES_HIDE: %s
+  TGO_CHANGED_SINCE: %s
+  TGO_MATRIX: %s
 
-`, f.Results.String(), f.Summary.String(), f.HideEmptyResults.String())
+`, f.Results.String(), f.Summary.String(), f.HideEmptyResults.String(), f.ChangedSince, f.Matrix.String())
 }
 
 func (f *Flags) Setup(args []string) {
@@ -216,6 +341,8 @@ func (f *Flags) Setup(args []string) {
 				StatusPass,
 				StatusNone,
 				StatusFail,
+				StatusTimeout,
+				StatusPanic,
 			}
 			f.HideEmptyResults = Statuses{
 				// StatusSkip,
@@ -227,6 +354,8 @@ func (f *Flags) Setup(args []string) {
 			f.Summary = Statuses{
 				StatusNone,
 				StatusFail,
+				StatusTimeout,
+				StatusPanic,
 				// StatusPass,
 			}
 		}
@@ -253,6 +382,12 @@ func (a Action) IsStatus(s Status) bool {
 	case StatusSkip:
 		return (a == ActionSkip)
 
+	case StatusTimeout:
+		return (a == ActionTimeout)
+
+	case StatusPanic:
+		return (a == ActionPanic)
+
 	default:
 		return false
 	}
@@ -278,6 +413,23 @@ func (s Status) IsAction(a Action) bool {
 	case ActionSkip:
 		return s == StatusSkip
 
+	case ActionTimeout:
+		return s == StatusTimeout
+
+	case ActionPanic:
+		return s == StatusPanic
+
+	default:
+		return false
+	}
+}
+
+// Failed reports if the status is a failure of any kind, a result without
+// an ending action included.
+func (s Status) Failed() bool {
+	switch s {
+	case StatusFail, StatusBuildFail, StatusNone, StatusTimeout, StatusPanic:
+		return true
 	default:
 		return false
 	}
@@ -366,6 +518,26 @@ type Event struct {
 	Elapsed     float64 // seconds
 	Output      string
 	FailedBuild string // package ID of the package that failed to build
+	OutputType  string // new in go 1.25: frame, error or error-continue
+	AttrKey     string `json:"Key,omitempty"`   // new in go 1.25 for attr
+	AttrValue   string `json:"Value,omitempty"` // new in go 1.25 for attr
+
+	Module  string  `json:"-"` // set by tgo when testing several modules
+	Variant string  `json:"-"` // set by tgo to the matrix cell label
+	GoTest  *GoTest `json:"-"` // the go test run that produced the event
+}
+
+// Output types set by go 1.25 and later, output of t.Log and of the code under
+// test has no type.
+const (
+	OutputTypeFrame         = "frame"
+	OutputTypeError         = "error"
+	OutputTypeErrorContinue = "error-continue"
+)
+
+// IsError reports if the event is output of t.Error, t.Fatal and friends.
+func (t Event) IsError() bool {
+	return t.OutputType == OutputTypeError || t.OutputType == OutputTypeErrorContinue
 }
 
 func (t Event) Key() Key {
@@ -374,22 +546,36 @@ func (t Event) Key() Key {
 		pkg = t.ImportPath
 	}
 	return Key{
+		Module:  t.Module,
 		Package: pkg,
 		Test:    t.Test,
+		Variant: t.Variant,
 	}
 }
 
 // Key identifies a package and test together.
 type Key struct {
+	Module  string
 	Package string
 	Test    string
+	Variant string
 }
 
 func (t Key) String() string {
-	if t.Test == "" {
-		return t.Package
+	s := t.Package
+	if t.Test != "" {
+		s += "." + t.Test
 	}
-	return t.Package + "." + t.Test
+	if t.Variant != "" {
+		s += " [" + t.Variant + "]"
+	}
+	return s
+}
+
+// IsSubtestOf reports if t is a subtest of parent at any depth.
+func (t Key) IsSubtestOf(parent Key) bool {
+	return t.Package == parent.Package && t.Module == parent.Module &&
+		t.Variant == parent.Variant && strings.HasPrefix(t.Test, parent.Test+"/")
 }
 
 type Events []Event
@@ -403,6 +589,12 @@ func (es Events) Clone() Events {
 }
 
 func (es Events) Status() Status {
+	if es.FindFirstByAction(ActionTimeout) != nil {
+		return StatusTimeout
+	}
+	if es.FindFirstByAction(ActionPanic) != nil {
+		return StatusPanic
+	}
 	for _, e := range es {
 		switch e.Action {
 
@@ -425,6 +617,17 @@ func (es Events) Status() Status {
 	return StatusNone
 }
 
+// Notes returns the text of the notes tgo added.
+func (es Events) Notes() []string {
+	var notes []string
+	for _, e := range es {
+		if e.Action == ActionNote {
+			notes = append(notes, strings.TrimSpace(e.Output))
+		}
+	}
+	return notes
+}
+
 func (es Events) FindFirstByAction(actions ...Action) *Event {
 	for _, v := range es {
 		if slices.Contains(actions, v.Action) {
@@ -467,6 +670,7 @@ loop:
 		output := strings.TrimLeft(e.Output, " ")
 		outputWS := strings.TrimSpace(e.Output)
 		if e.Action == "run" ||
+			e.OutputType == OutputTypeFrame ||
 			e.Action == "cont" ||
 			e.Action == "pause" ||
 			e.Action == "start" ||
@@ -485,6 +689,7 @@ loop:
 					(output == "PASS\n") ||
 					(output == "FAIL\n") ||
 					(output == "testing: warning: no tests to run\n") ||
+					(strings.HasPrefix(output, "-test.shuffle ")) ||
 					(strings.HasPrefix(outputWS, fmt.Sprintf("FAIL\t%s\t", e.Package))) ||
 					(strings.HasPrefix(outputWS, "coverage:") && strings.HasSuffix(outputWS, "of statements")))) {
 			continue loop
@@ -494,6 +699,17 @@ loop:
 	return v
 }
 
+// HasOutputTypes reports if the events come from a go version that sets the
+// OutputType of output events.
+func (es Events) HasOutputTypes() bool {
+	for _, e := range es {
+		if e.OutputType != "" {
+			return true
+		}
+	}
+	return false
+}
+
 func (es Events) IsPackageWithoutTest() bool {
 	for _, e := range es {
 		output := strings.TrimLeft(e.Output, " ")
@@ -508,6 +724,31 @@ func (es Events) IsPackageWithoutTest() bool {
 	return false
 }
 
+// FindShuffleSeed returns the seed a package was run with when -shuffle is
+// enabled.
+func (es Events) FindShuffleSeed() string {
+	for _, e := range es {
+		if e.Action != ActionOutput || e.Test != "" {
+			continue
+		}
+		if seed, ok := strings.CutPrefix(strings.TrimSpace(e.Output), "-test.shuffle "); ok {
+			return seed
+		}
+	}
+	return ""
+}
+
+// ShuffleReproCommand returns a command to run a package again with the same
+// shuffle seed.
+func (es Events) ShuffleReproCommand() string {
+	seed := es.FindShuffleSeed()
+	if seed == "" || len(es) == 0 {
+		return ""
+	}
+	e := es[0]
+	return e.GoTest.ReproCommand(e.Key().Package, "-shuffle="+seed, "-count=1")
+}
+
 func (es Events) FindCoverage() string {
 	if len(es) == 0 {
 		return ""
@@ -542,12 +783,36 @@ func (es Events) PrintDetail(flags Flags) {
 		return
 	}
 
+	// The goroutine dump of a panic attributed to this test is hidden, the
+	// condensed trace of its panic event is shown instead.
+	hasPanic := events.FindFirstByAction(ActionPanic) != nil
+	inTrace := false
+
+	// With go 1.25 and later error output is told apart from logs.
+	hasTypes := es.HasOutputTypes()
+	errorsOnly := flags.ErrorsOnly && hasTypes &&
+		(es.Status() == StatusFail || es.Status() == StatusPanic)
+
 	var filteredEvents Events
 loop:
 	for _, e := range events {
+		if hasPanic && flags.V <= V3 && e.Action == ActionOutput {
+			switch {
+			case isPanicLine(e.Output):
+				inTrace = true
+				continue loop
+			case inTrace && isTraceLine(e.Output):
+				continue loop
+			default:
+				inTrace = false
+			}
+		}
 		if flags.V <= V3 && strings.TrimSpace(e.Output) == "" {
 			continue loop
 		}
+		if errorsOnly && e.Action == ActionOutput && !e.IsError() {
+			continue loop
+		}
 		filteredEvents = append(filteredEvents, e)
 	}
 
@@ -574,6 +839,12 @@ loop:
 		textColor = skipColor
 	case StatusBench:
 		event = events.FindFirstByAction(ActionBench)
+	case StatusTimeout:
+		event = events.FindFirstByAction(ActionTimeout)
+		textColor = failColor
+	case StatusPanic:
+		event = events.FindFirstByAction(ActionFail, ActionPanic)
+		textColor = failColor
 	}
 
 	if event == nil {
@@ -590,6 +861,10 @@ loop:
 	}
 
 	var sb strings.Builder
+	if event.Variant != "" {
+		sb.WriteString("  ")
+		sb.WriteString(variantColor("[" + event.Variant + "]"))
+	}
 	if event.Elapsed >= 0.01 {
 		sb.WriteString("  ")
 		sb.WriteString(timeColor(fmt.Sprintf("(%.2fs)", event.Elapsed)))
@@ -606,6 +881,25 @@ loop:
 		sb.WriteString("[no tests]")
 	}
 
+	if es.HasRace() {
+		sb.WriteString("  ")
+		sb.WriteString(failColor("[data race]"))
+	}
+
+	if attrs := es.Attrs(); len(attrs) > 0 {
+		sb.WriteString("  ")
+		sb.WriteString(attrColor(attrs.String()))
+	}
+
+	var repro string
+	if status == StatusFail || status == StatusPanic {
+		if seed := es.FindShuffleSeed(); seed != "" {
+			sb.WriteString("  ")
+			sb.WriteString(timeColor("[shuffle " + seed + "]"))
+			repro = es.ShuffleReproCommand()
+		}
+	}
+
 	statusColor := statusColors[status]
 	statusBold := statusColorsBold[status]
 	fmt.Print(statusBold("===") +
@@ -614,10 +908,31 @@ loop:
 		sb.String() +
 		"\n",
 	)
+	if repro != "" {
+		fmt.Println("    " + repro)
+	}
 	if len(filteredEvents) > 0 {
 		fmt.Println("")
 	}
-	for _, e := range filteredEvents {
+	if flags.V <= V3 {
+		filteredEvents = filteredEvents.CompactTestify()
+	}
+	lines := make([]string, len(filteredEvents))
+	for i, e := range filteredEvents {
+		lines[i] = strings.TrimSuffix(e.Output, "\n")
+	}
+	diffs, hidden := diffLines(lines, flags.V <= V3)
+	for i, e := range filteredEvents {
+		if hidden[i] {
+			if i+1 == len(hidden) || !hidden[i+1] {
+				n := 1
+				for n <= i && hidden[i-n] {
+					n++
+				}
+				fmt.Println(collapsedLine(n))
+			}
+			continue
+		}
 		var ss []string
 		if flags.V >= V3 {
 			ss = append(ss, fmt.Sprintf("%7s", e.Action))
@@ -625,7 +940,22 @@ loop:
 		if flags.V >= V3 {
 			ss = append(ss, e.Time.Format("15:04:05.999"))
 		}
-		ss = append(ss, textColor(strings.TrimSuffix(e.Output, "\n")), "\n")
+		switch {
+		case e.Action == ActionPanic:
+			ss = append(ss, panicTrace(e.Output), "\n")
+		case diffs[i] != diffNone && diffs[i] != diffHeader:
+			ss = append(ss, diffColors[diffs[i]](lines[i]), "\n")
+		default:
+			c := textColor
+			switch {
+			case !hasTypes || e.Action != ActionOutput:
+			case e.IsError():
+				c = failColorBold
+			case e.OutputType == "":
+				c = logColor
+			}
+			ss = append(ss, linkLocation(flags, e, lines[i], c), "\n")
+		}
 		fmt.Print(strings.Join(ss, " "))
 	}
 	if len(filteredEvents) > 0 {
@@ -641,8 +971,12 @@ func (ts TestStorage) OrderedKeys() []Key {
 		tks = append(tks, k)
 	}
 	sort.SliceStable(tks, func(i, j int) bool {
+		if tks[i].Module != tks[j].Module {
+			return natural.Less(tks[i].Module, tks[j].Module)
+		}
 		if (tks[i].Package == tks[j].Package) &&
-			(tks[i].Test == "" || tks[j].Test == "") {
+			(tks[i].Test == "" || tks[j].Test == "") &&
+			tks[i].Test != tks[j].Test {
 			return len(tks[i].Test) > len(tks[j].Test)
 		}
 		return natural.Less(tks[i].String(), tks[j].String())
@@ -665,6 +999,19 @@ func (ts TestStorage) Append(e Event) {
 	ts[key] = events
 }
 
+// Note adds a note with text to the result of key.
+func (ts TestStorage) Note(key Key, text string) {
+	ts.Append(Event{
+		Time:    time.Now(),
+		Action:  ActionNote,
+		Module:  key.Module,
+		Package: key.Package,
+		Test:    key.Test,
+		Variant: key.Variant,
+		Output:  text + "\n",
+	})
+}
+
 func (ts TestStorage) Union(values ...TestStorage) TestStorage {
 	tests := make(TestStorage, len(ts))
 	maps.Copy(tests, ts)
@@ -807,8 +1154,10 @@ func (ts TestStorage) PrintShortSummary(status Status) {
 	tests := ts.FindPackageResults()
 
 	fmt.Println(hr, header, hr)
+	var module string
 	for _, key := range tests.OrderedKeys() {
 		events := ts[key]
+		module = printModuleHeader(module, key)
 
 		var sb strings.Builder
 
@@ -831,6 +1180,10 @@ func (ts TestStorage) PrintShortSummary(status Status) {
 			sb.WriteString("  ")
 			sb.WriteString(coverColor(fmt.Sprintf("{%s}", coverage)))
 		}
+		if seed := events.FindShuffleSeed(); seed != "" && events.Status() == StatusFail {
+			sb.WriteString("  ")
+			sb.WriteString(timeColor("[shuffle " + seed + "]"))
+		}
 		fmt.Print(prefix +
 			packageColor(key.Package) +
 			sb.String() +
@@ -842,22 +1195,41 @@ func (ts TestStorage) PrintShortSummary(status Status) {
 
 func (ts TestStorage) PrintSummary(status Status) {
 	// count := ts.CountTests()
+	printSummaryHeader(status)
+	ts.printSummaryRows(status, ts.OrderedKeys())
+}
+
+func printSummaryHeader(status Status) {
 	statusColor := statusColors[status]
 	statusBold := statusColorsBold[status]
 	header := statusBold(statusNames[status])
 	hr := statusColor("════════════")
-	prefix := statusColor(fmt.Sprintf("%6s ", statusNames[status]))
-
 	fmt.Println(hr, header, hr)
-	for _, key := range ts.OrderedKeys() {
+}
+
+// printSummaryRows prints the summary lines of keys.
+func (ts TestStorage) printSummaryRows(status Status, keys []Key) {
+	statusColor := statusColors[status]
+	prefix := statusColor(fmt.Sprintf("%6s ", statusNames[status]))
+	var module string
+	for _, key := range keys {
 		events := ts[key]
+		module = printModuleHeader(module, key)
 
 		var sb strings.Builder
 
+		if key.Variant != "" {
+			sb.WriteString("  ")
+			sb.WriteString(variantColor("[" + key.Variant + "]"))
+		}
 		if fe := events.FindFirstByAction(EndingActions...); fe != nil && fe.Elapsed >= 0.01 {
 			sb.WriteString("  ")
 			sb.WriteString(timeColor(fmt.Sprintf("(%.2fs)", fe.Elapsed)))
 		}
+		for _, note := range events.Notes() {
+			sb.WriteString("  ")
+			sb.WriteString(noneColor("(" + note + ")"))
+		}
 		if key.Test == "" {
 			if events.IsPackageWithoutTest() {
 				sb.WriteString("  ")
@@ -868,6 +1240,10 @@ func (ts TestStorage) PrintSummary(status Status) {
 				sb.WriteString("  ")
 				sb.WriteString(coverColor(fmt.Sprintf("{%s}", coverage)))
 			}
+			if seed := events.FindShuffleSeed(); seed != "" && events.Status() == StatusFail {
+				sb.WriteString("  ")
+				sb.WriteString(timeColor("[shuffle " + seed + "]"))
+			}
 			fmt.Print(prefix +
 				packageColor(key.Package) +
 				sb.String() +
@@ -880,10 +1256,38 @@ func (ts TestStorage) PrintSummary(status Status) {
 				sb.String() +
 				"\n",
 			)
+			if status == StatusFail || status == StatusPanic {
+				fmt.Println("       " + ts.ReproCommand(key))
+			}
 		}
 	}
 }
 
+// ReproCommand returns a command that runs only the test of key again with
+// the flags it was run with, and the shuffle seed of its package if any.
+func (ts TestStorage) ReproCommand(key Key) string {
+	extra := []string{"-run=" + RunPattern(key.Test), "-count=1"}
+	pkgKey := key
+	pkgKey.Test = ""
+	if seed := ts[pkgKey].FindShuffleSeed(); seed != "" {
+		extra = append(extra, "-shuffle="+seed)
+	}
+	var gt *GoTest
+	if events := ts[key]; len(events) > 0 {
+		gt = events[0].GoTest
+	}
+	return gt.ReproCommand(key.Package, extra...)
+}
+
+// printModuleHeader prints the module name when key belongs to another module
+// than the previous key and returns the module of key.
+func printModuleHeader(module string, key Key) string {
+	if key.Module != "" && key.Module != module {
+		fmt.Println(coverColor("  ── " + key.Module))
+	}
+	return key.Module
+}
+
 func (ts TestStorage) PrintCoverage() {
 	hr := coverColor("════════════")
 	var prefix string
@@ -964,7 +1368,13 @@ func main() {
 		}
 	}()
 
-	if err := run(ctx, flags, os.Args[1:]); err != nil {
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "bench-compare" {
+		err = benchCompare(ctx, flags, os.Args[2:])
+	} else {
+		err = run(ctx, flags, os.Args[1:])
+	}
+	if err != nil {
 		var ee ExitError
 		if errors.As(err, &ee) {
 			os.Exit(int(ee))
@@ -978,6 +1388,21 @@ func run(ctx context.Context, flags Flags, argv []string) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if flags.Stress {
+		return stress(ctx, flags, argv)
+	}
+
+	if flags.ChangedSince != "" {
+		selected, ok, err := selectChanged(ctx, flags, argv)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		argv = selected
+	}
+
 	var coverEnabled bool
 	for _, v := range argv {
 		if v == "-cover" {
@@ -985,54 +1410,235 @@ func run(ctx context.Context, flags Flags, argv []string) error {
 		}
 	}
 
-	args := []string{"test", "-json"}
-	args = append(args, argv...)
-	log.Println("args", args)
-	cmd := exec.CommandContext(ctx, flags.Bin, args...)
-	cmd.Stderr = os.Stderr
+	gts := []*GoTest{{Args: argv}}
+	if flags.Modules {
+		var err error
+		gts, err = moduleTests(ctx, flags, argv)
+		if err != nil {
+			return err
+		}
+	}
+	gts = matrixTests(flags.Matrix, gts)
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
+	var baseline []Benchmark
+	if flags.BenchBaseline != "" {
+		var err error
+		baseline, err = LoadBenchBaseline(flags.BenchBaseline)
+		if err != nil {
+			return err
+		}
 	}
-	defer stdout.Close()
 
-	if err := cmd.Start(); err != nil {
-		fmt.Println(err)
-		return err
+	var history *History
+	historyPath := flags.History
+	if historyPath == "auto" {
+		historyPath = DefaultHistoryPath()
+	}
+	if historyPath != "" {
+		var err error
+		history, err = LoadHistory(historyPath)
+		if err != nil {
+			log.Println("history:", err)
+		}
+	}
+
+	jobs := flags.ModuleJobs
+	if flags.Jobs > 0 {
+		var err error
+		gts, err = scheduleTests(ctx, flags, gts, history)
+		if err != nil {
+			return err
+		}
+		jobs = flags.Jobs
+	}
+
+	// Stopping early has to stop the test binaries too, go test itself only
+	// passes the interrupt on to them when it comes from the terminal.
+	if flags.StopOnFail || flags.MaxFailures > 0 {
+		for _, gt := range gts {
+			gt.ProcessGroup = true
+		}
 	}
 
 	t0 := time.Now()
 
 	tests := make(TestStorage, 0)
 	printed := make(map[Key]bool, 0)
-	scanner := bufio.NewScanner(stdout)
 
-	fmt.Println("*****")
-scan:
-	for scanner.Scan() {
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	events := make(chan Event)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- runGoTests(runCtx, flags.Bin, gts, jobs, events)
+	}()
 
+	var (
+		stopReason string
+		failures   failureCounter
+		hangs      *hangDetector
+		tick       <-chan time.Time
+	)
+	if flags.HangAfter > 0 {
+		hangs = newHangDetector(flags.Bin, flags.HangAfter, flags.HangQuit)
+		ticker := time.NewTicker(min(flags.HangAfter/4, time.Second))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	fmt.Println("*****")
+loop:
+	for {
 		var e Event
-		log.Println("LINE:", scanner.Text())
-		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
-			log.Println("scanner error", err)
-			continue scan
+		select {
+		case now := <-tick:
+			hangs.Check(now, tests)
+			continue loop
+		case ev, ok := <-events:
+			if !ok {
+				break loop
+			}
+			e = ev
+		}
+		if stopReason != "" {
+			continue
+		}
+		if hangs != nil {
+			e = hangs.Event(e)
 		}
 		tests.Append(e)
 		key := e.Key()
-		if !printed[key] && flags.Results.HasAction(e.Action) {
+		var output []string
+		if e.Action == ActionFail && (key.Test == "" || tests[key].HasPanic()) {
+			output = tests.PackageOutput(key)
+			for _, k := range tests.MarkPanics(key, output) {
+				if !printed[k] && flags.Results.Any(StatusPanic) {
+					tests[k].PrintDetail(flags)
+					printed[k] = true
+				}
+			}
+		}
+		if key.Test == "" && e.Action == ActionFail {
+			for _, k := range tests.MarkTimeouts(key, output) {
+				if !printed[k] && flags.Results.Any(StatusTimeout) {
+					tests[k].PrintDetail(flags)
+					printed[k] = true
+				}
+			}
+			// tests still running when an interrupted go test exits did
+			// not crash
+			if ctx.Err() == nil {
+				for _, k := range tests.MarkCrashes(key, output) {
+					if !printed[k] && flags.Results.Any(StatusPanic) {
+						tests[k].PrintDetail(flags)
+						printed[k] = true
+					}
+				}
+			}
+		}
+		// Failed subtests are printed when their top level test or package
+		// ends, go test writes the panic of a subtest to the output of the
+		// top level test so it is only known then.
+		subtestFail := e.Action == ActionFail && strings.Contains(key.Test, "/")
+		if e.Action == ActionFail && !subtestFail && flags.Results.Any(StatusFail) {
+			for _, k := range tests.OrderedKeys() {
+				if k.Package != key.Package || k.Module != key.Module || k.Variant != key.Variant ||
+					!strings.Contains(k.Test, "/") || key.Test != "" && !k.IsSubtestOf(key) {
+					continue
+				}
+				if !printed[k] && tests[k].Status() == StatusFail {
+					tests[k].PrintDetail(flags)
+					printed[k] = true
+					history.PrintLastPassDiff(k, tests[k])
+				}
+			}
+		}
+		if !printed[key] && !subtestFail && flags.Results.HasAction(e.Action) {
+			tests[key].PrintDetail(flags)
+			printed[key] = true
+			if e.Action == ActionFail {
+				history.PrintLastPassDiff(key, tests[key])
+			}
+		}
+		if !failures.Add(e) {
+			continue
+		}
+		switch {
+		case flags.StopOnFail:
+			stopReason = "stopped at first failure"
+		case flags.MaxFailures > 0 && failures.Count() >= flags.MaxFailures:
+			stopReason = fmt.Sprintf("max failures (%d) reached", flags.MaxFailures)
+		default:
+			continue
+		}
+		if !printed[key] {
 			tests[key].PrintDetail(flags)
 			printed[key] = true
 		}
+		stop()
+	}
+	runErr := <-errc
+	if stopReason != "" {
+		packages, err := listPackageKeys(ctx, flags.Bin, gts)
+		if err != nil {
+			log.Println("list packages:", err)
+		}
+		notRun, cutShort := tests.MarkStopped(stopReason, packages)
+		fmt.Println(failColorBold(fmt.Sprintf("%s: %d packages not run, %d cut short", stopReason, notRun, cutShort)))
+		if runErr == nil {
+			runErr = ExitError(1)
+		}
+	} else if flags.Isolate {
+		tests.Isolate(ctx, flags)
+	}
+
+	var comparisons []BenchComparison
+	if baseline != nil {
+		comparisons = CompareBenchmarks(baseline, tests.Benchmarks())
 	}
 
-	if len(tests) > 0 {
+	tests.PrintReport(flags, printed, coverEnabled, t0, comparisons)
+
+	if flags.BenchThreshold > 0 && runErr == nil {
+		for _, c := range comparisons {
+			if c.Regression(flags.BenchThreshold) {
+				runErr = ExitError(1)
+				break
+			}
+		}
+	}
+
+	if flags.JSONSummary != "" {
+		if err := tests.WriteJSONSummary(flags.JSONSummary, t0); err != nil {
+			log.Println("json summary:", err)
+		}
+	}
+
+	if flags.Quickfix != "" {
+		if err := tests.WriteQuickfix(flags.Bin, flags.Quickfix); err != nil {
+			log.Println("quickfix:", err)
+		}
+	}
+
+	if history != nil {
+		history.Record(tests)
+		if err := history.Save(); err != nil {
+			log.Println("history:", err)
+		}
+	}
+	return runErr
+}
+
+// PrintReport prints the results that were not printed while running followed
+// by the summaries and the status line.
+func (ts TestStorage) PrintReport(flags Flags, printed map[Key]bool, coverEnabled bool, t0 time.Time, comparisons []BenchComparison) {
+	if len(ts) > 0 {
 		if flags.Results.Any(StatusNone) {
-			noneTests := tests.
+			noneTests := ts.
 				FilterKeys(printed).
 				FilterAction(EndingActions...)
 			for _, key := range noneTests.OrderedKeys() {
-				tests[key].PrintDetail(flags)
+				ts[key].PrintDetail(flags)
 				printed[key] = true
 			}
 		}
@@ -1040,20 +1646,25 @@ scan:
 		// print summaries
 		for _, status := range flags.Summary {
 			if status == StatusNone {
-				filtered := tests.FilterAction(EndingActions...)
+				filtered := ts.FilterAction(EndingActions...)
 				if len(filtered) > 0 {
-					filtered.PrintSummary(status)
+					ts.printSummary(flags, filtered, status)
 				}
 			} else if status == StatusBuildFail {
-				filtered := tests.FindByAction(ActionBuildFail)
+				filtered := ts.FindByAction(ActionBuildFail)
 				if len(filtered) > 0 {
-					filtered.PrintSummary(status)
+					ts.printSummary(flags, filtered, status)
 				}
 			} else {
 				for _, action := range EndingActions {
 					if status.IsAction(action) {
 
-						filtered := tests.FindByAction(action)
+						filtered := ts.FindByAction(action)
+
+						// Tests that panicked are listed in their own summary.
+						if action == ActionFail {
+							filtered = filtered.FilterAction(ActionPanic)
+						}
 
 						if action == ActionSkip {
 							if flags.V <= V3 {
@@ -1062,7 +1673,7 @@ scan:
 						}
 
 						if len(filtered) > 0 {
-							filtered.PrintSummary(status)
+							ts.printSummary(flags, filtered, status)
 						}
 
 					}
@@ -1070,31 +1681,52 @@ scan:
 			}
 		}
 
+		if len(comparisons) > 0 {
+			PrintBenchComparison(comparisons, "baseline", "current", flags.BenchThreshold)
+		} else {
+			ts.PrintBenchmarks()
+		}
+
+		ts.PrintRaceSummary()
+
+		if flags.Summary.Any(StatusFail) {
+			ts.PrintClusterSummary(flags)
+		}
+
+		if len(flags.Matrix.Cells()) > 1 {
+			ts.PrintVariantSummary()
+		}
+
 		if coverEnabled {
-			filtered := tests.WithCoverage()
+			filtered := ts.WithCoverage()
 			if len(filtered) > 0 {
 				filtered.PrintCoverage()
 			}
 		}
 
 		{
-			allFail := tests.FindByAction(ActionFail)
-			allBuildFail := tests.FindByAction(ActionBuildFail)
-			allPass := tests.FindByAction(ActionPass)
-			allSkip := tests.FindByAction(ActionSkip)
-			allNone := tests.FilterAction(EndingActions...)
+			allFail := ts.FindByAction(ActionFail).FilterAction(ActionPanic)
+			allBuildFail := ts.FindByAction(ActionBuildFail)
+			allPass := ts.FindByAction(ActionPass)
+			allSkip := ts.FindByAction(ActionSkip)
+			allNone := ts.FilterAction(EndingActions...)
+			allTimeout := ts.FindByAction(ActionTimeout)
+			allPanic := ts.FindByAction(ActionPanic)
 
 			countPass := allPass.CountTests()
 			countFail := allFail.CountTests()
 			countBuildFail := allBuildFail.CountTests()
 			countNone := len(allNone)
 			countSkip := allSkip.CountTests()
+			countTimeout := allTimeout.CountTests()
+			countPanic := allPanic.CountTests()
 
 			pass := statusNames[StatusPass] + ":" + fmt.Sprint(countPass)
 			fail := statusNames[StatusFail] + ":" + fmt.Sprint(countFail)
 			buildfail := statusNames[StatusBuildFail] + ":" + fmt.Sprint(countBuildFail)
 			none := statusNames[StatusNone] + ":" + fmt.Sprint(countNone)
 			skip := statusNames[StatusSkip] + ":" + fmt.Sprint(countSkip)
+			var timeout, panics string
 
 			statusColor := hardLineColor
 
@@ -1114,6 +1746,16 @@ scan:
 				buildfail = statusColor(buildfail)
 			}
 
+			if countTimeout > 0 {
+				statusColor = failColorBold
+				timeout = statusColor(statusNames[StatusTimeout] + ":" + fmt.Sprint(countTimeout))
+			}
+
+			if countPanic > 0 {
+				statusColor = failColorBold
+				panics = statusColor(statusNames[StatusPanic] + ":" + fmt.Sprint(countPanic))
+			}
+
 			// if countSkip > 0 {
 			// skip = skipColorBold(skip)
 			// }
@@ -1124,8 +1766,13 @@ scan:
 				statusColor(time.Now().Format("15:04:05")) +
 				sep + pass +
 				sep + fail +
-				sep + buildfail +
-				sep + none +
+				sep + buildfail
+			for _, s := range []string{timeout, panics} {
+				if s != "" {
+					status += sep + s
+				}
+			}
+			status += sep + none +
 				sep + skip +
 				sep + statusColor(time.Now().Sub(t0).Round(time.Millisecond).String()) +
 				"  " + statusColor("══════")
@@ -1134,22 +1781,4 @@ scan:
 
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		fmt.Println("error reading standard input:", err)
-	}
-	go stdout.Close()
-
-	go func() {
-		time.Sleep(2 * time.Second)
-		cancel()
-	}()
-	cmdErr := cmd.Wait()
-	var ee *exec.ExitError
-	if cmdErr != nil && errors.As(cmdErr, &ee) {
-		if ee.Exited() {
-			return ExitError(ee.ExitCode())
-		}
-	}
-	return nil
 }
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRun_Pass(t *testing.T) {
@@ -79,13 +83,171 @@ func TestRun_Output(t *testing.T) {
 }
 
 func TestRun_Crash(t *testing.T) {
+	flags := Flags{
+		Bin:     "go",
+		Results: Statuses{StatusFail, StatusNone, StatusPanic},
+		Summary: Statuses{StatusFail, StatusNone, StatusPanic},
+	}
+	var err error
+	out := captureStdout(t, func() {
+		err = run(context.Background(), flags, []string{"./testdata/crash"})
+	})
+	if err == nil {
+		t.Errorf("expected error for crashed test, got nil")
+	}
+	if !strings.Contains(out, "=== PANIC github.com/some-programs/tgo/testdata/crash.TestCrash") ||
+		!strings.Contains(out, strings.TrimSpace(crashNote)) {
+		t.Errorf("expected TestCrash to be reported as crashed:\n%s", out)
+	}
+	if !strings.Contains(out, "PANIC:1") || !strings.Contains(out, "NONE:0") {
+		t.Errorf("unexpected status line:\n%s", out)
+	}
+}
+
+func TestRun_StopOnFail(t *testing.T) {
+	flags := Flags{
+		Bin:        "go",
+		Results:    Statuses{StatusFail, StatusNone},
+		Summary:    Statuses{StatusFail, StatusNone},
+		StopOnFail: true,
+	}
+	var err error
+	t0 := time.Now()
+	out := captureStdout(t, func() {
+		err = run(context.Background(), flags, []string{"./testdata/fail", "./testdata/slow"})
+	})
+	if err == nil {
+		t.Errorf("expected error for stopped run, got nil")
+	}
+	if d := time.Since(t0); d > 15*time.Second {
+		t.Errorf("expected the slow package to be stopped, run took %s", d)
+	}
+	if !strings.Contains(out, "stopped at first failure: ") {
+		t.Errorf("expected a stop message:\n%s", out)
+	}
+	if !regexp.MustCompile(`NONE github.com/some-programs/tgo/testdata/slow.*\((cut short|not run), stopped at first failure\)`).MatchString(out) {
+		t.Errorf("expected the slow package to be marked as stopped:\n%s", out)
+	}
+}
+
+func TestRun_MaxFailures(t *testing.T) {
+	flags := Flags{
+		Bin:         "go",
+		Results:     Statuses{StatusFail, StatusNone, StatusPanic},
+		Summary:     Statuses{StatusFail, StatusNone, StatusPanic},
+		MaxFailures: 1,
+	}
+	var err error
+	out := captureStdout(t, func() {
+		err = run(context.Background(), flags, []string{"./testdata/fail", "./testdata/slow"})
+	})
+	if err == nil {
+		t.Errorf("expected error for stopped run, got nil")
+	}
+	if !strings.Contains(out, "max failures (1) reached: ") {
+		t.Errorf("expected a stop message:\n%s", out)
+	}
+
+	// the failing test and the crashed package are two distinct failures,
+	// the failed packages do not count again
+	flags.MaxFailures = 3
+	out = captureStdout(t, func() {
+		err = run(context.Background(), flags, []string{"./testdata/fail", "./testdata/crash"})
+	})
+	if err == nil {
+		t.Errorf("expected error for failing run, got nil")
+	}
+	if strings.Contains(out, "max failures") {
+		t.Errorf("expected the run not to be stopped:\n%s", out)
+	}
+	if !strings.Contains(out, "FAIL:1") || !strings.Contains(out, "PANIC:1") {
+		t.Errorf("unexpected status line:\n%s", out)
+	}
+}
+
+func TestRun_Stress(t *testing.T) {
+	flags := Flags{
+		Bin:         "go",
+		Results:     Statuses{StatusFail, StatusNone},
+		Summary:     Statuses{StatusFail, StatusNone},
+		Stress:      true,
+		StressP:     2,
+		StressCount: 4,
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+	if err := run(context.Background(), flags, []string{"./testdata/pass"}); err != nil {
+		t.Errorf("expected no error for passing stress run, got %v", err)
+	}
+	if err := run(context.Background(), flags, []string{"./testdata/fail"}); err == nil {
+		t.Errorf("expected error for failing stress run, got nil")
+	}
+	if err := run(context.Background(), flags, []string{"-vet=off", "-json", "-cover", "./testdata/pass"}); err != nil {
+		t.Errorf("expected go test only flags to be accepted, got %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		// the binaries must run through -exec, false fails every run
+		if err := run(context.Background(), flags, []string{"-exec", "false", "./testdata/pass"}); err == nil {
+			t.Errorf("expected error for stress run with -exec false, got nil")
+		}
+	}
+}
+
+func TestRun_Isolate(t *testing.T) {
 	flags := Flags{
 		Bin:     "go",
 		Results: Statuses{StatusFail, StatusNone},
 		Summary: Statuses{StatusFail, StatusNone},
+		Isolate: true,
 	}
-	err := run(context.Background(), flags, []string{"./testdata/crash"})
+	var err error
+	out := captureStdout(t, func() {
+		err = run(context.Background(), flags, []string{"./testdata/fail", "./testdata/orderdep"})
+	})
 	if err == nil {
-		t.Errorf("expected error for crashed test, got nil")
+		t.Errorf("expected error for failing test, got nil")
+	}
+	if !strings.Contains(out, "isolating 2 failed tests") {
+		t.Errorf("expected both failed tests to be isolated:\n%s", out)
+	}
+	for test, note := range map[string]string{
+		"testdata/fail.TestFail":      isolationFails,
+		"testdata/orderdep.TestClean": isolationPasses,
+	} {
+		if !regexp.MustCompile(`FAIL \S*` + regexp.QuoteMeta(test) + `.*\(` + regexp.QuoteMeta(note) + `\)`).MatchString(out) {
+			t.Errorf("expected %s to be noted %q:\n%s", test, note, out)
+		}
+	}
+}
+
+func TestRun_Panic(t *testing.T) {
+	flags := Flags{
+		Bin:     "go",
+		Results: Statuses{StatusFail, StatusNone, StatusPanic},
+		Summary: Statuses{StatusFail, StatusNone, StatusPanic},
+	}
+	var err error
+	out := captureStdout(t, func() {
+		err = run(context.Background(), flags, []string{"./testdata/panic"})
+	})
+	if err == nil {
+		t.Errorf("expected error for panicking test, got nil")
+	}
+	if n := strings.Count(out, "=== PANIC "); n != 1 {
+		t.Errorf("expected one PANIC block, got %d:\n%s", n, out)
+	}
+	if !strings.Contains(out, "=== PANIC github.com/some-programs/tgo/testdata/panic.TestPanic/sub\n") {
+		t.Errorf("expected the panic to be attributed to TestPanic/sub:\n%s", out)
+	}
+	if strings.Contains(out, "FAIL github.com/some-programs/tgo/testdata/panic.TestPanic/sub") {
+		t.Errorf("expected no separate FAIL entry for TestPanic/sub:\n%s", out)
+	}
+	if !strings.Contains(out, "-run=^TestPanic$/^sub$") {
+		t.Errorf("expected the repro command to target the subtest:\n%s", out)
+	}
+	if !strings.Contains(out, "→ panic.TestPanic.func1  testdata/panic/panic_test.go:8") {
+		t.Errorf("expected the user frame to be marked:\n%s", out)
+	}
+	if strings.Contains(out, "goroutine ") {
+		t.Errorf("expected the goroutine dump to be hidden:\n%s", out)
 	}
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// TimedOutTest is a test listed as running when a test binary timed out.
+type TimedOutTest struct {
+	Test    string
+	Elapsed time.Duration
+}
+
+// ParseTimeout finds the "panic: test timed out after" message in the output
+// of a test binary and returns the tests it lists as running.
+func ParseTimeout(lines []string) (after string, tests []TimedOutTest, ok bool) {
+	for i, line := range lines {
+		after, ok = strings.CutPrefix(strings.TrimSpace(line), "panic: test timed out after ")
+		if !ok {
+			continue
+		}
+		for _, line := range lines[i+1:] {
+			line = strings.TrimSpace(line)
+			if line == "running tests:" {
+				continue
+			}
+			name, elapsed, found := strings.Cut(line, " (")
+			if !found || !strings.HasSuffix(elapsed, ")") {
+				break
+			}
+			d, err := time.ParseDuration(strings.TrimSuffix(elapsed, ")"))
+			if err != nil {
+				break
+			}
+			tests = append(tests, TimedOutTest{Test: name, Elapsed: d})
+		}
+		return after, tests, true
+	}
+	return "", nil, false
+}
+
+// PackageOutput returns the output lines of every result in the package of
+// key in the order they were produced.
+func (ts TestStorage) PackageOutput(key Key) []string {
+	var events Events
+	for k, es := range ts {
+		if k.Package == key.Package && k.Module == key.Module && k.Variant == key.Variant {
+			for _, e := range es {
+				if e.Action == ActionOutput {
+					events = append(events, e)
+				}
+			}
+		}
+	}
+	return events.outputLines()
+}
+
+// OutputByPackage returns the output lines of every package keyed by its
+// package result key, it reads the storage once for all packages.
+func (ts TestStorage) OutputByPackage() map[Key][]string {
+	events := make(map[Key]Events)
+	for k, es := range ts {
+		pkgKey := k
+		pkgKey.Test = ""
+		for _, e := range es {
+			if e.Action == ActionOutput {
+				events[pkgKey] = append(events[pkgKey], e)
+			}
+		}
+	}
+	output := make(map[Key][]string, len(events))
+	for k, es := range events {
+		output[k] = es.outputLines()
+	}
+	return output
+}
+
+// outputLines joins the output of events in the order it was produced and
+// splits it into lines.
+func (es Events) outputLines() []string {
+	es.SortByTime()
+	var sb strings.Builder
+	for _, e := range es {
+		sb.WriteString(e.Output)
+	}
+	return strings.Split(sb.String(), "\n")
+}
+
+// MarkTimeouts adds a timeout event to every test that was running when the
+// package of key timed out according to its output and returns their keys.
+func (ts TestStorage) MarkTimeouts(key Key, output []string) []Key {
+	after, timedOut, ok := ParseTimeout(output)
+	if !ok {
+		return nil
+	}
+	var keys []Key
+	now := time.Now()
+	for _, t := range timedOut {
+		k := key
+		k.Test = t.Test
+		ts.Append(Event{
+			Time:    now,
+			Action:  ActionTimeout,
+			Module:  k.Module,
+			Package: k.Package,
+			Test:    k.Test,
+			Variant: k.Variant,
+			Elapsed: t.Elapsed.Seconds(),
+			Output:  "test timed out after " + after + "\n",
+		})
+		keys = append(keys, k)
+	}
+	return keys
+}
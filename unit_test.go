@@ -1,12 +1,22 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/fatih/color"
 )
 
 func TestFlags_Register(t *testing.T) {
@@ -76,6 +86,8 @@ func TestAction_Methods(t *testing.T) {
 		{ActionFail, StatusFail, true},
 		{ActionSkip, StatusSkip, true},
 		{ActionBench, StatusBench, true},
+		{ActionTimeout, StatusTimeout, true},
+		{ActionPanic, StatusPanic, true},
 		{ActionPass, StatusFail, false},
 		{ActionRun, StatusPass, false},
 	}
@@ -101,6 +113,8 @@ func TestStatus_Methods(t *testing.T) {
 		{StatusFail, ActionFail, true},
 		{StatusSkip, ActionSkip, true},
 		{StatusBench, ActionBench, true},
+		{StatusTimeout, ActionTimeout, true},
+		{StatusPanic, ActionPanic, true},
 		{StatusPass, ActionFail, false},
 		{StatusNone, ActionPass, false},
 	}
@@ -442,3 +456,1663 @@ func TestPrintingFunctions(t *testing.T) {
 		}
 	})
 }
+
+func TestParseTestArgs(t *testing.T) {
+	ta := ParseTestArgs([]string{"-run", "TestA", "-v", "-count=1", "./...", "-bench", ".", "pkg", "-args", "-x", "y"})
+	if got := strings.Join(ta.Flags, " "); got != "-run TestA -v -count=1 -bench ." {
+		t.Errorf("unexpected flags: %s", got)
+	}
+	if got := strings.Join(ta.Packages, " "); got != "./... pkg" {
+		t.Errorf("unexpected packages: %s", got)
+	}
+	if got := strings.Join(ta.Args, " "); got != "-args -x y" {
+		t.Errorf("unexpected args: %s", got)
+	}
+	if v, ok := ta.Lookup("run"); !ok || v != "TestA" {
+		t.Errorf("unexpected run value: %q %v", v, ok)
+	}
+	if v, ok := ta.Lookup("count"); !ok || v != "1" {
+		t.Errorf("unexpected count value: %q %v", v, ok)
+	}
+	if v, ok := ta.Lookup("v"); !ok || v != "true" {
+		t.Errorf("unexpected v value: %q %v", v, ok)
+	}
+	if _, ok := ta.Lookup("race"); ok {
+		t.Error("unexpected race flag")
+	}
+	got := strings.Join(ta.WithPackages("a", "b").Argv(), " ")
+	if got != "-run TestA -v -count=1 -bench . a b -args -x y" {
+		t.Errorf("unexpected argv: %s", got)
+	}
+	got = strings.Join(ta.Without("run", "count").Argv(), " ")
+	if got != "-v -bench . ./... pkg -args -x y" {
+		t.Errorf("unexpected argv without run and count: %s", got)
+	}
+
+	build, goTest, binary := ParseTestArgs([]string{"-race", "-tags", "x", "-vet=off", "-exec", "echo", "-run", "TestA", "-test.count=2", "-short", "./..."}).SplitFlags()
+	if got := strings.Join(build, " "); got != "-race -tags=x" {
+		t.Errorf("unexpected build flags: %s", got)
+	}
+	if got := strings.Join(goTest, " "); got != "-vet=off -exec=echo" {
+		t.Errorf("unexpected go test flags: %s", got)
+	}
+	if got := strings.Join(binary, " "); got != "-test.run=TestA -test.count=2 -test.short" {
+		t.Errorf("unexpected binary flags: %s", got)
+	}
+}
+
+func TestSelectChangedPackages(t *testing.T) {
+	pkgs := []ListPackage{
+		{ImportPath: "m/a", Name: "a", Dir: "/m/a"},
+		{ImportPath: "m/b", Name: "b", Dir: "/m/b", Deps: []string{"m/a"}},
+		{ImportPath: "m/c", Name: "c", Dir: "/m/c"},
+		{ImportPath: "m/c [m/c.test]", Name: "c", Dir: "/m/c", ForTest: "m/c"},
+		{ImportPath: "m/c.test", Name: "main", Dir: "/m/c", Deps: []string{"m/a", "m/b", "m/c [m/c.test]", "m/e"}},
+		{ImportPath: "m/d", Name: "d", Dir: "/m/d"},
+		{ImportPath: "m/e", Name: "e", Dir: "/m/e", DepOnly: true},
+	}
+	selected := SelectChangedPackages(pkgs, []string{"/m/a/a.go", "/m/d/testdata/x.json", "/m/e/e.go"})
+	want := []SelectedPackage{
+		{ImportPath: "m/a", Reason: "changed a.go"},
+		{ImportPath: "m/b", Reason: "depends on m/a"},
+		{ImportPath: "m/c", Reason: "depends on m/a and 1 more"},
+		{ImportPath: "m/d", Reason: "changed x.json"},
+	}
+	if len(selected) != len(want) {
+		t.Fatalf("expected %v, got %v", want, selected)
+	}
+	for i := range want {
+		if selected[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], selected[i])
+		}
+	}
+}
+
+func TestFindModules(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"go.mod":                "module example.com/root\n",
+		"sub/go.mod":            "module \"example.com/sub\" // comment\n",
+		"testdata/x/go.mod":     "module example.com/ignored\n",
+		"vendor/example/go.mod": "module example.com/vendored\n",
+	} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modules, err := FindModules(context.Background(), "go", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Module{
+		{Path: "example.com/root", Dir: dir},
+		{Path: "example.com/sub", Dir: filepath.Join(dir, "sub")},
+	}
+	if len(modules) != len(want) {
+		t.Fatalf("expected %v, got %v", want, modules)
+	}
+	for i := range want {
+		if modules[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], modules[i])
+		}
+	}
+}
+
+func TestModulePatterns(t *testing.T) {
+	root := filepath.FromSlash("/repo")
+	modules := []Module{
+		{Path: "example.com/root", Dir: root},
+		{Path: "example.com/root/sub", Dir: filepath.Join(root, "sub")},
+		{Path: "example.com/other", Dir: filepath.Join(root, "other")},
+	}
+	for _, tc := range []struct {
+		wd       string
+		patterns []string
+		want     map[string][]string
+	}{
+		{root, []string{"./..."}, map[string][]string{
+			root:                         {"./..."},
+			filepath.Join(root, "sub"):   {"./..."},
+			filepath.Join(root, "other"): {"./..."},
+		}},
+		{root, []string{"./sub/x", "./a"}, map[string][]string{
+			root:                       {"./a"},
+			filepath.Join(root, "sub"): {"./x"},
+		}},
+		{filepath.Join(root, "sub"), []string{"."}, map[string][]string{
+			filepath.Join(root, "sub"): {"."},
+		}},
+		{root, []string{"example.com/root/sub/x", "example.com/root/a", "example.com/other/..."}, map[string][]string{
+			root:                         {"example.com/root/a"},
+			filepath.Join(root, "sub"):   {"example.com/root/sub/x"},
+			filepath.Join(root, "other"): {"example.com/other/..."},
+		}},
+		{root, []string{"example.com/root/..."}, map[string][]string{
+			root:                       {"example.com/root/..."},
+			filepath.Join(root, "sub"): {"./..."},
+		}},
+		{root, []string{"example.com/unknown"}, map[string][]string{}},
+	} {
+		got := ModulePatterns(tc.wd, modules, tc.patterns)
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: expected %v, got %v", tc.patterns, tc.want, got)
+			continue
+		}
+		for dir, want := range tc.want {
+			if !slices.Equal(got[dir], want) {
+				t.Errorf("%v: expected %v in %s, got %v", tc.patterns, want, dir, got[dir])
+			}
+		}
+	}
+}
+
+func TestTestStorage_Modules(t *testing.T) {
+	ts := make(TestStorage)
+	ts.Append(Event{Module: "m/b", Package: "m/b", Test: "TestB", Action: ActionFail})
+	ts.Append(Event{Module: "m/a", Package: "m/a/x", Test: "TestA", Action: ActionFail})
+	ts.Append(Event{Module: "m/a", Package: "m/a/x", Action: ActionFail})
+
+	keys := ts.OrderedKeys()
+	if len(keys) != 3 || keys[0].Module != "m/a" || keys[2].Module != "m/b" {
+		t.Errorf("unexpected order: %v", keys)
+	}
+
+	got := captureStdout(t, func() {
+		ts.PrintSummary(StatusFail)
+	})
+	if strings.Count(got, "── m/a") != 1 || strings.Count(got, "── m/b") != 1 {
+		t.Errorf("expected one header per module: %q", got)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	var m Matrix
+	if err := m.Set("tags=,integration;race=off,on"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("env:GOEXPERIMENT=x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("novalues"); err == nil {
+		t.Error("expected error for dimension without values")
+	}
+	if m.String() != "tags=,integration;race=off,on;env:GOEXPERIMENT=x" {
+		t.Errorf("unexpected matrix string: %s", m.String())
+	}
+
+	cells := m.Cells()
+	if len(cells) != 4 {
+		t.Fatalf("expected 4 cells, got %d", len(cells))
+	}
+	last := cells[3]
+	if last.Label != "tags=integration race=on env:GOEXPERIMENT=x" {
+		t.Errorf("unexpected label: %s", last.Label)
+	}
+	if strings.Join(last.Flags, " ") != "-tags=integration -race" {
+		t.Errorf("unexpected flags: %v", last.Flags)
+	}
+	if strings.Join(last.Env, " ") != "GOEXPERIMENT=x" {
+		t.Errorf("unexpected env: %v", last.Env)
+	}
+	if len(cells[0].Flags) != 0 {
+		t.Errorf("expected no flags for first cell, got %v", cells[0].Flags)
+	}
+
+	gts := matrixTests(m, []*GoTest{{Args: []string{"-v", "./..."}}})
+	if len(gts) != 4 || strings.Join(gts[3].Args, " ") != "-v -tags=integration -race ./..." {
+		t.Errorf("unexpected go tests: %v", gts)
+	}
+}
+
+func TestTestStorage_PrintVariantSummary(t *testing.T) {
+	ts := make(TestStorage)
+	ts.Append(Event{Variant: "race=off", Package: "pkg", Test: "TestA", Action: ActionPass})
+	ts.Append(Event{Variant: "race=on", Package: "pkg", Test: "TestA", Action: ActionFail})
+	ts.Append(Event{Variant: "race=off", Package: "pkg", Test: "TestB", Action: ActionFail})
+	ts.Append(Event{Variant: "race=on", Package: "pkg", Test: "TestB", Action: ActionFail})
+	ts.Append(Event{Variant: "race=off", Package: "pkg", Test: "TestC", Action: ActionPass})
+	ts.Append(Event{Variant: "race=on", Package: "pkg", Test: "TestC", Action: ActionTimeout})
+	ts.Append(Event{Variant: "race=off", Package: "pkg", Test: "TestD", Action: ActionPanic})
+	ts.Append(Event{Variant: "race=on", Package: "pkg", Test: "TestD", Action: ActionPass})
+
+	got := captureStdout(t, func() {
+		ts.PrintVariantSummary()
+	})
+	if !strings.Contains(got, "VARIANTS") || !strings.Contains(got, "TestA") {
+		t.Errorf("expected TestA in variant summary: %q", got)
+	}
+	if strings.Contains(got, "TestB") {
+		t.Errorf("TestB fails in every variant: %q", got)
+	}
+	if !strings.Contains(got, "TestC") || !strings.Contains(got, "TestD") {
+		t.Errorf("expected timeouts and panics in variant summary: %q", got)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tgo", "history.json")
+	h, err := LoadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ts := make(TestStorage)
+	ts.Append(Event{Time: now, Package: "pkg", Action: ActionPass, Elapsed: 1.5})
+	ts.Append(Event{Time: now, Package: "pkg", Test: "TestA", Action: ActionPass, Elapsed: 1.4})
+	ts.Append(Event{Package: "pkg_none", Action: ActionOutput, Output: "hello\n"})
+	h.Record(ts)
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = LoadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, ok := h.Elapsed("pkg"); !ok || elapsed != 1.5 {
+		t.Errorf("unexpected elapsed: %v %v", elapsed, ok)
+	}
+	if _, ok := h.Elapsed("pkg_none"); ok {
+		t.Error("unfinished package should not be recorded")
+	}
+	var nilHistory *History
+	if _, ok := nilHistory.Elapsed("pkg"); ok {
+		t.Error("nil history should be empty")
+	}
+
+	old := time.Now().Add(-historyMaxAge - time.Hour)
+	h.Packages["gone"] = PackageHistory{Elapsed: 1, Time: old}
+	h.Tests["gone TestA"] = LastPass{Output: []string{"a"}, Time: old}
+	h.Tests["pkg TestB"] = LastPass{Output: []string{"b"}, Time: time.Now()}
+	h.Record(make(TestStorage))
+	if _, ok := h.Elapsed("gone"); ok {
+		t.Error("expected packages not seen for historyMaxAge to be pruned")
+	}
+	if _, ok := h.Tests["gone TestA"]; ok {
+		t.Error("expected tests not seen for historyMaxAge to be pruned")
+	}
+	if _, ok := h.Tests["pkg TestB"]; !ok {
+		t.Error("expected recently seen tests to be kept")
+	}
+}
+
+func TestScheduleTests(t *testing.T) {
+	const prefix = "github.com/some-programs/tgo/testdata/"
+	schedule := func(history *History) []string {
+		gts, err := scheduleTests(context.Background(), Flags{Bin: "go"}, []*GoTest{
+			{Args: []string{"-count=1", "./testdata/pass", "./testdata/fail", "./testdata/skip"}},
+		}, history)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, gt := range gts {
+			got = append(got, strings.Join(gt.Args, " "))
+		}
+		return got
+	}
+
+	tests := []struct {
+		name    string
+		history *History
+		want    []string
+	}{
+		{
+			name: "no history",
+			want: []string{
+				"-count=1 " + prefix + "pass",
+				"-count=1 " + prefix + "fail",
+				"-count=1 " + prefix + "skip",
+			},
+		},
+		{
+			name: "history",
+			history: &History{Packages: map[string]PackageHistory{
+				prefix + "pass": {Elapsed: 1},
+				prefix + "fail": {Elapsed: 2},
+			}},
+			want: []string{
+				"-count=1 " + prefix + "skip",
+				"-count=1 " + prefix + "fail",
+				"-count=1 " + prefix + "pass",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := schedule(tt.history)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFailureCounter(t *testing.T) {
+	var fc failureCounter
+	events := []struct {
+		e    Event
+		want bool
+	}{
+		{Event{Package: "pkg", Test: "TestA/sub", Action: ActionFail}, true},
+		{Event{Package: "pkg", Test: "TestA", Action: ActionFail}, false},
+		{Event{Package: "pkg", Test: "TestB", Action: ActionPass}, false},
+		{Event{Package: "pkg", Test: "TestC", Action: ActionFail}, true},
+		{Event{Package: "pkg", Action: ActionFail}, false},
+		{Event{Package: "crash", Action: ActionFail}, true},
+		{Event{ImportPath: "build [build.test]", Action: ActionBuildFail}, true},
+		{Event{Package: "build", Action: ActionFail, FailedBuild: "build [build.test]"}, false},
+	}
+	for _, tt := range events {
+		if got := fc.Add(tt.e); got != tt.want {
+			t.Errorf("Add(%v) = %v, want %v", tt.e.Key(), got, tt.want)
+		}
+	}
+	if got := fc.Count(); got != 4 {
+		t.Errorf("Count() = %d, want 4", got)
+	}
+
+	// two packages with one failing test each are two failures
+	fc = failureCounter{}
+	for _, pkg := range []string{"a", "b"} {
+		fc.Add(Event{Package: pkg, Test: "TestA", Action: ActionFail})
+		fc.Add(Event{Package: pkg, Action: ActionFail})
+	}
+	if got := fc.Count(); got != 2 {
+		t.Errorf("Count() = %d, want 2", got)
+	}
+}
+
+func TestTestStorage_MarkStopped(t *testing.T) {
+	ts := make(TestStorage)
+	ts.Append(Event{Package: "pkg", Test: "TestA", Action: ActionFail})
+	ts.Append(Event{Package: "pkg", Test: "TestB", Action: ActionRun})
+	ts.Append(Event{Package: "pkg", Action: ActionStart})
+	ts.Append(Event{Package: "done", Action: ActionPass})
+
+	notRun, cutShort := ts.MarkStopped("stopped", []Key{{Package: "pkg"}, {Package: "done"}, {Package: "other"}})
+	if notRun != 1 || cutShort != 1 {
+		t.Errorf("expected 1 not run and 1 cut short, got %d and %d", notRun, cutShort)
+	}
+	if notes := ts[Key{Package: "pkg", Test: "TestB"}].Notes(); len(notes) != 1 || notes[0] != "cut short, stopped" {
+		t.Errorf("unexpected notes: %v", notes)
+	}
+	if notes := ts[Key{Package: "other"}].Notes(); len(notes) != 1 || notes[0] != "not run, stopped" {
+		t.Errorf("unexpected notes: %v", notes)
+	}
+	if ts[Key{Package: "other"}].Status() != StatusNone {
+		t.Error("expected not run package to have no status")
+	}
+	if notes := ts[Key{Package: "pkg", Test: "TestA"}].Notes(); len(notes) != 0 {
+		t.Errorf("unexpected notes on finished test: %v", notes)
+	}
+}
+
+func TestEvents_FindShuffleSeed(t *testing.T) {
+	gt := &GoTest{Args: []string{"-shuffle", "on", "-race", "-v", "-run", "Test A", "./..."}}
+	es := Events{
+		{Package: "pkg", Action: ActionStart, GoTest: gt},
+		{Package: "pkg", Action: ActionOutput, Output: "-test.shuffle 123\n", GoTest: gt},
+		{Package: "pkg", Action: ActionFail, GoTest: gt},
+	}
+	if seed := es.FindShuffleSeed(); seed != "123" {
+		t.Errorf("expected seed 123, got %q", seed)
+	}
+	if got := es.ShuffleReproCommand(); got != "go test -race '-run=Test A' -shuffle=123 -count=1 pkg" {
+		t.Errorf("unexpected repro command: %s", got)
+	}
+	if compacted := es.Compact(); len(compacted) != 1 || compacted[0].Action != ActionFail {
+		t.Errorf("expected shuffle output to be compacted: %v", compacted)
+	}
+	if (Events{{Package: "pkg", Action: ActionFail}}).ShuffleReproCommand() != "" {
+		t.Error("expected no repro command without shuffle seed")
+	}
+}
+
+func TestGoTest_ReproCommand(t *testing.T) {
+	gt := &GoTest{
+		Dir:  "/src/mod",
+		Env:  []string{"GOEXPERIMENT=x"},
+		Args: []string{"-tags", "a b", "-count=3", "-bench", ".", "-run=TestA"},
+	}
+	got := gt.ReproCommand("pkg", "-run=^TestB$", "-count=1")
+	want := "cd /src/mod && GOEXPERIMENT=x go test '-tags=a b' '-run=^TestB$' -count=1 pkg"
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	var nilGoTest *GoTest
+	if got := nilGoTest.ReproCommand("pkg"); got != "go test pkg" {
+		t.Errorf("unexpected repro command: %s", got)
+	}
+}
+
+func TestRunPattern(t *testing.T) {
+	tests := map[string]string{
+		"TestA":                   "^TestA$",
+		"TestA/case_with_spaces":  "^TestA$/^case_with_spaces$",
+		"TestA/a+b/(x)":           `^TestA$/^a\+b$/^\(x\)$`,
+		"TestA/#00":               "^TestA$/^#00$",
+		"TestA/with.dot_and[set]": `^TestA$/^with\.dot_and\[set\]$`,
+	}
+	for test, want := range tests {
+		if got := RunPattern(test); got != want {
+			t.Errorf("RunPattern(%q) = %q, want %q", test, got, want)
+		}
+	}
+}
+
+func TestTestStorage_FailedLeafTests(t *testing.T) {
+	ts := make(TestStorage)
+	ts.Append(Event{Package: "pkg", Test: "TestA/sub", Action: ActionFail})
+	ts.Append(Event{Package: "pkg", Test: "TestA", Action: ActionFail})
+	ts.Append(Event{Package: "pkg", Test: "TestB", Action: ActionFail})
+	ts.Append(Event{Package: "pkg", Test: "TestC", Action: ActionPass})
+	ts.Append(Event{Package: "pkg", Action: ActionFail})
+
+	keys := ts.FailedLeafTests()
+	if len(keys) != 2 || keys[0].Test != "TestA/sub" || keys[1].Test != "TestB" {
+		t.Errorf("unexpected leaf tests: %v", keys)
+	}
+}
+
+func TestTestStorage_ReproCommand(t *testing.T) {
+	gt := &GoTest{Args: []string{"-race", "-shuffle=on", "-run", "Test", "-v", "./..."}}
+	ts := make(TestStorage)
+	ts.Append(Event{Package: "pkg", Action: ActionOutput, Output: "-test.shuffle 42\n", GoTest: gt})
+	ts.Append(Event{Package: "pkg", Test: "TestA/with spaces", Action: ActionFail, GoTest: gt})
+	ts.Append(Event{Package: "pkg", Action: ActionFail, GoTest: gt})
+
+	want := "go test -race '-run=^TestA$/^with spaces$' -count=1 -shuffle=42 pkg"
+	if got := ts.ReproCommand(Key{Package: "pkg", Test: "TestA/with spaces"}); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	got := captureStdout(t, func() {
+		ts.PrintSummary(StatusFail)
+	})
+	if !strings.Contains(got, want) {
+		t.Errorf("expected repro command in summary: %q", got)
+	}
+}
+
+func TestHangDetector(t *testing.T) {
+	ts := make(TestStorage)
+	hd := newHangDetector("go", time.Minute, false)
+	for _, e := range []Event{
+		{Package: "pkg", Test: "TestA", Action: ActionRun},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionRun},
+		{Package: "pkg", Test: "TestB", Action: ActionRun},
+		{Package: "pkg", Test: "TestB", Action: ActionPass},
+		{Package: "pkg", Test: "TestC", Action: ActionRun},
+		{Package: "pkg", Test: "TestC", Action: ActionPause},
+	} {
+		ts.Append(hd.Event(e))
+	}
+
+	got := captureStdout(t, func() {
+		hd.Check(time.Now(), ts)
+	})
+	if got != "" {
+		t.Errorf("expected no hang warnings yet: %q", got)
+	}
+
+	got = captureStdout(t, func() {
+		hd.Check(time.Now().Add(2*time.Minute), ts)
+		hd.Check(time.Now().Add(3*time.Minute), ts)
+	})
+	if strings.Count(got, "HANG") != 1 || !strings.Contains(got, "pkg.TestA/sub") {
+		t.Errorf("expected one hang warning for pkg.TestA/sub: %q", got)
+	}
+	if notes := ts[Key{Package: "pkg", Test: "TestA/sub"}].Notes(); len(notes) != 1 {
+		t.Errorf("expected a note on the hanging test: %v", notes)
+	}
+
+	// the goroutine dump of a quit package goes to the hanging test only
+	hd.quitted[Key{Package: "pkg"}] = Key{Package: "pkg", Test: "TestA/sub"}
+	for _, e := range []Event{
+		{Package: "pkg", Action: ActionOutput, Output: "SIGQUIT: quit\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "goroutine 1 [running]:\n"},
+		{Package: "pkg", Action: ActionOutput, Output: "FAIL\tpkg\t60s\n", OutputType: OutputTypeFrame},
+	} {
+		ts.Append(hd.Event(e))
+	}
+	if out := ts[Key{Package: "pkg", Test: "TestA/sub"}].CompactOutput(); len(out) != 2 {
+		t.Errorf("expected the dump in the hanging test: %q", out)
+	}
+	if len(ts[Key{Package: "pkg", Test: "TestA"}].CompactOutput()) != 0 || len(ts[Key{Package: "pkg"}]) != 1 {
+		t.Errorf("expected the dump only once, got %v", ts)
+	}
+}
+
+func TestTestBinaryPid(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only supported on linux")
+	}
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip(err)
+	}
+	data, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "pkg.test")
+	if err := os.WriteFile(bin, data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin, "10")
+	cmd.Dir = dir
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	pid, err := testBinaryPid(os.Getpid(), dir)
+	if err != nil || pid != cmd.Process.Pid {
+		t.Errorf("got %d, %v, want %d", pid, err, cmd.Process.Pid)
+	}
+	if _, err := testBinaryPid(os.Getpid(), t.TempDir()); err == nil {
+		t.Error("expected no test binary in another directory")
+	}
+}
+
+func TestTestStorage_OutputByPackage(t *testing.T) {
+	ts := make(TestStorage)
+	now := time.Now()
+	for i, e := range []Event{
+		{Package: "a", Test: "TestA", Action: ActionOutput, Output: "=== RUN   TestA\n"},
+		{Package: "b", Action: ActionOutput, Output: "b\n"},
+		{Package: "a", Test: "TestA/sub", Action: ActionOutput, Output: "sub\n"},
+		{Package: "a", Variant: "race", Action: ActionOutput, Output: "race\n"},
+		{Package: "a", Action: ActionOutput, Output: "FAIL\n"},
+		{Package: "a", Action: ActionFail},
+	} {
+		e.Time = now.Add(time.Duration(i) * time.Millisecond)
+		ts.Append(e)
+	}
+	output := ts.OutputByPackage()
+	if len(output) != 3 {
+		t.Fatalf("expected 3 packages, got %v", output)
+	}
+	for _, key := range []Key{{Package: "a"}, {Package: "b"}, {Package: "a", Variant: "race"}} {
+		got, want := strings.Join(output[key], "|"), strings.Join(ts.PackageOutput(key), "|")
+		if got != want {
+			t.Errorf("%v: expected %q, got %q", key, want, got)
+		}
+	}
+	if got := strings.Join(output[Key{Package: "a"}], "|"); got != "=== RUN   TestA|sub|FAIL|" {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestTestStorage_MarkTimeouts(t *testing.T) {
+	ts := make(TestStorage)
+	now := time.Now()
+	for i, e := range []Event{
+		{Package: "pkg", Test: "TestA", Action: ActionRun},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionRun},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionOutput, Output: "panic: test timed out after 2s\n"},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionOutput, Output: "\trunning tests:\n"},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionOutput, Output: "\t\tTestA (2s)\n"},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionOutput, Output: "\t\tTestA/sub (1.5s)\n"},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionOutput, Output: "\n"},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionOutput, Output: "goroutine 9 [running]:\n"},
+		{Package: "pkg", Action: ActionFail, Elapsed: 2},
+	} {
+		e.Time = now.Add(time.Duration(i) * time.Millisecond)
+		ts.Append(e)
+	}
+
+	keys := ts.MarkTimeouts(Key{Package: "pkg"}, ts.PackageOutput(Key{Package: "pkg"}))
+	if len(keys) != 2 || keys[0].Test != "TestA" || keys[1].Test != "TestA/sub" {
+		t.Fatalf("unexpected timed out tests: %v", keys)
+	}
+	sub := ts[Key{Package: "pkg", Test: "TestA/sub"}]
+	if sub.Status() != StatusTimeout {
+		t.Errorf("expected timeout status, got %s", sub.Status())
+	}
+	if e := sub.FindFirstByAction(ActionTimeout); e == nil || e.Elapsed != 1.5 {
+		t.Errorf("unexpected timeout event: %v", e)
+	}
+	if ts[Key{Package: "pkg"}].Status() != StatusFail {
+		t.Error("expected package to stay failed")
+	}
+	if len(ts.FilterAction(EndingActions...)) != 0 {
+		t.Error("expected no results without status")
+	}
+	if keys := ts.MarkTimeouts(Key{Package: "other"}, ts.PackageOutput(Key{Package: "other"})); len(keys) != 0 {
+		t.Errorf("unexpected timeouts: %v", keys)
+	}
+}
+
+func TestParsePanic(t *testing.T) {
+	lines := strings.Split(`=== RUN   TestA
+--- FAIL: TestA (0.00s)
+panic: assignment to entry in nil map [recovered]
+	panic: assignment to entry in nil map
+
+goroutine 8 [running]:
+testing.tRunner.func1.2({0x6b6e20, 0x6ef0c0})
+	/usr/local/go/src/testing/testing.go:2123 +0x232
+panic({0x6b6e20?, 0x6ef0c0?})
+	/usr/local/go/src/runtime/panic.go:859 +0x125
+example.com/pkg.helper(...)
+	/home/me/pkg/a_test.go:7
+example.com/pkg.TestA.func1(0x361491f746c8?)
+	/home/me/pkg/a_test.go:14 +0x29
+testing.tRunner(0x361491f746c8, 0x6d48b8)
+	/usr/local/go/src/testing/testing.go:2193 +0xea
+created by testing.(*T).Run in goroutine 7
+	/usr/local/go/src/testing/testing.go:2258 +0x4d4
+FAIL	example.com/pkg	0.005s`, "\n")
+
+	p, ok := ParsePanic(lines)
+	if !ok {
+		t.Fatal("expected a panic")
+	}
+	if len(p.Message) != 2 || p.Message[0] != "panic: assignment to entry in nil map [recovered]" {
+		t.Errorf("unexpected message: %q", p.Message)
+	}
+	if len(p.Frames) != 6 {
+		t.Fatalf("expected 6 frames, got %d", len(p.Frames))
+	}
+	if name := p.TestName(); name != "TestA" {
+		t.Errorf("expected TestA, got %q", name)
+	}
+	user, ok := p.UserFrame()
+	if !ok || user.Func != "example.com/pkg.helper" || user.File != "/home/me/pkg/a_test.go" || user.Line != 7 {
+		t.Errorf("unexpected user frame: %+v", user)
+	}
+	want := "panic: assignment to entry in nil map [recovered]\n" +
+		"\tpanic: assignment to entry in nil map\n" +
+		"→ pkg.helper  /home/me/pkg/a_test.go:7\n" +
+		"  pkg.TestA.func1  /home/me/pkg/a_test.go:14\n"
+	if got := p.Condensed(); got != want {
+		t.Errorf("unexpected condensed trace:\n%s", got)
+	}
+
+	if _, ok := ParsePanic([]string{"panic: test timed out after 1s", "running tests:"}); ok {
+		t.Error("expected timeouts not to be panics")
+	}
+	if _, ok := ParsePanic([]string{"ok"}); ok {
+		t.Error("expected no panic")
+	}
+}
+
+func TestShortPath(t *testing.T) {
+	wd, _ := os.Getwd()
+	tests := []struct {
+		path, want string
+	}{
+		{filepath.Join(wd, "a_test.go"), "a_test.go"},
+		{"/usr/local/go/src/net/http/server.go", "net/http/server.go"},
+		{"/home/me/go/pkg/mod/example.com/x@v1.0.0/x.go", "example.com/x@v1.0.0/x.go"},
+		{"/elsewhere/x.go", "/elsewhere/x.go"},
+	}
+	for _, tt := range tests {
+		if got := ShortPath(tt.path, "/usr/local/go"); got != tt.want {
+			t.Errorf("ShortPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTestStorage_MarkPanics(t *testing.T) {
+	ts := make(TestStorage)
+	now := time.Now()
+	for i, e := range []Event{
+		{Package: "pkg", Test: "TestA", Action: ActionRun},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionRun},
+		{Package: "pkg", Test: "TestA/sub", Action: ActionFail},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "panic: boom\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "goroutine 8 [running]:\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "example.com/pkg.TestA.func1(0x0)\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "\t/home/me/pkg/a_test.go:14 +0x29\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionFail},
+		{Package: "pkg", Action: ActionFail},
+	} {
+		e.Time = now.Add(time.Duration(i) * time.Millisecond)
+		ts.Append(e)
+	}
+
+	if !ts[Key{Package: "pkg", Test: "TestA"}].HasPanic() {
+		t.Error("expected output of TestA to contain a panic")
+	}
+	// go test wrote the panic output under TestA, the panic goes to the
+	// subtest that failed
+	keys := ts.MarkPanics(Key{Package: "pkg"}, ts.PackageOutput(Key{Package: "pkg"}))
+	if len(keys) != 1 || keys[0].Test != "TestA/sub" {
+		t.Fatalf("unexpected panicked tests: %v", keys)
+	}
+	if status := ts[keys[0]].Status(); status != StatusPanic {
+		t.Errorf("expected panic status, got %s", status)
+	}
+	if ts[Key{Package: "pkg", Test: "TestA"}].Status() != StatusFail {
+		t.Error("expected the parent test to stay failed")
+	}
+	if ts[Key{Package: "pkg", Test: "TestA"}].HasPanic() || !ts[keys[0]].HasPanic() {
+		t.Error("expected the goroutine dump to move to the subtest")
+	}
+	if keys := ts.MarkPanics(Key{Package: "pkg"}, ts.PackageOutput(Key{Package: "pkg"})); len(keys) != 0 {
+		t.Errorf("expected panics to be marked once, got %v", keys)
+	}
+
+	// of several subtests the one that was still running panicked
+	ts = make(TestStorage)
+	for i, e := range []Event{
+		{Package: "pkg", Test: "TestA", Action: ActionRun},
+		{Package: "pkg", Test: "TestA/ok", Action: ActionRun},
+		{Package: "pkg", Test: "TestA/ok", Action: ActionPass},
+		{Package: "pkg", Test: "TestA/bad", Action: ActionRun},
+		{Package: "pkg", Test: "TestA/bad/inner", Action: ActionRun},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "panic: boom\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "goroutine 8 [running]:\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "example.com/pkg.TestA.func2.1(0x0)\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "\t/home/me/pkg/a_test.go:20 +0x29\n"},
+		{Package: "pkg", Action: ActionFail},
+	} {
+		e.Time = now.Add(time.Duration(i) * time.Millisecond)
+		ts.Append(e)
+	}
+	if keys := ts.MarkPanics(Key{Package: "pkg"}, ts.PackageOutput(Key{Package: "pkg"})); len(keys) != 1 || keys[0].Test != "TestA/bad/inner" {
+		t.Errorf("expected the running subtest to be marked, got %v", keys)
+	}
+}
+
+func TestFrame_TestName(t *testing.T) {
+	tests := map[string]string{
+		"example.com/pkg.TestA.func1":    "TestA",
+		"example.com/pkg.Test_a":         "Test_a",
+		"example.com/pkg.BenchmarkX":     "BenchmarkX",
+		"example.com/pkg.TestMain":       "",
+		"example.com/pkg.Testify":        "",
+		"example.com/pkg.helper":         "",
+		"testing.tRunner":                "",
+		"example.com/pkg.(*T).TestThing": "",
+	}
+	for fn, want := range tests {
+		if got := (Frame{Func: fn}).TestName(); got != want {
+			t.Errorf("TestName(%q) = %q, want %q", fn, got, want)
+		}
+	}
+}
+
+func TestTestStorage_MarkCrashes(t *testing.T) {
+	ts := make(TestStorage)
+	ts.Append(Event{Package: "pkg", Test: "TestA", Action: ActionRun})
+	ts.Append(Event{Package: "pkg", Test: "TestA", Action: ActionPass})
+	ts.Append(Event{Package: "pkg", Test: "TestB", Action: ActionRun})
+	ts.Append(Event{Package: "pkg", Test: "TestB/sub", Action: ActionRun})
+	ts.Append(Event{Package: "pkg", Action: ActionFail})
+
+	keys := ts.MarkCrashes(Key{Package: "pkg"}, ts.PackageOutput(Key{Package: "pkg"}))
+	if len(keys) != 1 || keys[0].Test != "TestB/sub" {
+		t.Fatalf("unexpected crashed tests: %v", keys)
+	}
+	if status := ts[keys[0]].Status(); status != StatusPanic {
+		t.Errorf("expected panic status, got %s", status)
+	}
+
+	ts = make(TestStorage)
+	ts.Append(Event{Package: "pkg", Test: "TestA", Action: ActionRun})
+	ts.Append(Event{Package: "pkg", Action: ActionOutput, Output: "SIGQUIT: quit\n"})
+	ts.Append(Event{Package: "pkg", Action: ActionFail})
+	if keys := ts.MarkCrashes(Key{Package: "pkg"}, ts.PackageOutput(Key{Package: "pkg"})); len(keys) != 0 {
+		t.Errorf("expected no crash for a requested goroutine dump, got %v", keys)
+	}
+}
+
+func TestEvents_PrintDetail_PanicTrace(t *testing.T) {
+	trace := []string{
+		"panic: assignment to entry in nil map\n",
+		"\n",
+		"goroutine 1 [running]:\n",
+		"example.com/pkg.init.0()\n",
+		"\t/home/me/pkg/a.go:5 +0x1d\n",
+	}
+	var es Events
+	for _, line := range trace {
+		es = append(es, Event{Package: "pkg", Action: ActionOutput, Output: line})
+	}
+	es = append(es, Event{Package: "pkg", Action: ActionFail})
+
+	// a panic that was not attributed to a test keeps its trace
+	out := captureStdout(t, func() { es.PrintDetail(Flags{}) })
+	for _, want := range []string{"goroutine 1 [running]:", "example.com/pkg.init.0()", "a.go:5"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	// the trace of an attributed panic is hidden up to the first line that
+	// is not part of it
+	es = es[:len(es)-1]
+	es = append(es,
+		Event{Package: "pkg", Action: ActionOutput, Output: "after the trace\n"},
+		Event{Package: "pkg", Action: ActionOutput, Output: "\tindented (log)\n"},
+		Event{Package: "pkg", Action: ActionPanic, Output: "panic: boom\n"},
+	)
+	out = captureStdout(t, func() { es.PrintDetail(Flags{}) })
+	if strings.Contains(out, "goroutine 1") {
+		t.Errorf("expected the trace to be hidden:\n%s", out)
+	}
+	for _, want := range []string{"after the trace", "indented (log)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+const raceOutput = `==================
+WARNING: DATA RACE
+Read at 0x0000008344e8 by goroutine 9:
+  example.com/pkg.inc()
+      /home/me/pkg/a_test.go:10 +0x75
+
+Previous write at 0x0000008344e8 by goroutine 8:
+  example.com/pkg.inc()
+      /home/me/pkg/a_test.go:10 +0x8d
+
+Goroutine 9 (running) created at:
+  example.com/pkg.race()
+      /home/me/pkg/a_test.go:16 +0x56
+  testing.tRunner()
+      /usr/local/go/src/testing/testing.go:2193 +0x21c
+==================
+    testing.go:1865: race detected during execution of test`
+
+func TestParseRaces(t *testing.T) {
+	races := ParseRaces(strings.Split(raceOutput, "\n"))
+	if len(races) != 1 {
+		t.Fatalf("expected 1 race, got %d", len(races))
+	}
+	r := races[0]
+	if len(r.Accesses) != 2 || r.Accesses[0].Kind != "read" || r.Accesses[1].Kind != "previous write" {
+		t.Fatalf("unexpected accesses: %+v", r.Accesses)
+	}
+	if len(r.Created) != 1 || len(r.Created[0].Frames) != 2 {
+		t.Fatalf("unexpected creation sites: %+v", r.Created)
+	}
+	want := []string{
+		"read at pkg.inc  /home/me/pkg/a_test.go:10",
+		"previous write at pkg.inc  /home/me/pkg/a_test.go:10",
+		"goroutine created at pkg.race  /home/me/pkg/a_test.go:16",
+	}
+	if got := r.Lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected lines: %q", got)
+	}
+	other := strings.ReplaceAll(strings.ReplaceAll(raceOutput, "0x0000008344e8", "0x00c000012345"), "goroutine 9", "goroutine 21")
+	if ParseRaces(strings.Split(other, "\n"))[0].Signature() != r.Signature() {
+		t.Error("expected signature to ignore addresses and goroutine ids")
+	}
+	swapped := strings.NewReplacer(
+		"Read at", "Write at",
+		"Previous write at", "Previous read at",
+		"by goroutine 9", "by goroutine 8",
+		"by goroutine 8", "by goroutine 9",
+		"Goroutine 9", "Goroutine 8",
+	).Replace(raceOutput)
+	if ParseRaces(strings.Split(swapped, "\n"))[0].Signature() != r.Signature() {
+		t.Error("expected signature not to depend on which access is reported first")
+	}
+}
+
+func TestTestStorage_Races(t *testing.T) {
+	ts := make(TestStorage)
+	for _, key := range []Key{
+		{Package: "pkg", Test: "TestA"},
+		{Package: "pkg", Test: "TestB", Variant: "race"},
+	} {
+		for _, line := range strings.SplitAfter(raceOutput, "\n") {
+			ts.Append(Event{Package: key.Package, Test: key.Test, Variant: key.Variant, Action: ActionOutput, Output: line})
+		}
+	}
+	ts.Append(Event{Package: "pkg", Test: "TestC", Action: ActionPass})
+
+	reports := ts.Races()
+	if len(reports) != 1 || len(reports[0].Keys) != 2 {
+		t.Fatalf("expected one race found by two tests, got %+v", reports)
+	}
+	if !ts[Key{Package: "pkg", Test: "TestA"}].HasRace() || ts[Key{Package: "pkg", Test: "TestC"}].HasRace() {
+		t.Error("unexpected HasRace result")
+	}
+	ts.PrintRaceSummary()
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		line   string
+		indent string
+		want   Location
+		ok     bool
+	}{
+		{"    a_test.go:12: got 1", "    ", Location{File: "a_test.go", Line: 12, Message: "got 1"}, true},
+		{"./x.go:3:5: undefined: y", "", Location{File: "./x.go", Line: 3, Col: 5, Message: "undefined: y"}, true},
+		{"/abs/x_test.go:7:", "", Location{File: "/abs/x_test.go", Line: 7}, true},
+		{"    plain output", "", Location{}, false},
+		{"see x.go for details", "", Location{}, false},
+	}
+	for _, tt := range tests {
+		indent, loc, ok := ParseLocation(tt.line)
+		if ok != tt.ok || indent != tt.indent || loc != tt.want {
+			t.Errorf("ParseLocation(%q) = %q, %+v, %v", tt.line, indent, loc, ok)
+		}
+	}
+	if s := (Location{File: "/a/x.go", Line: 3, Col: 5}).String(); s != "/a/x.go:3:5" {
+		t.Errorf("unexpected String() = %q", s)
+	}
+	if loc := (Location{File: "x.go"}).Resolve("/a"); loc.File != "/a/x.go" {
+		t.Errorf("unexpected Resolve() = %q", loc.File)
+	}
+}
+
+func TestLinkLocation(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = noColor }()
+
+	wd, _ := os.Getwd()
+	loc := Location{File: filepath.Join(wd, "testdata/fail/fail_test.go"), Line: 6}
+	if got := hyperlink("vscode://file/{path}:{line}", loc, "x"); got !=
+		"\x1b]8;;vscode://file/"+filepath.ToSlash(loc.File)+":6\x1b\\x\x1b]8;;\x1b\\" {
+		t.Errorf("unexpected hyperlink %q", got)
+	}
+	if got := hyperlink("", loc, "x"); got != "x" {
+		t.Errorf("expected no hyperlink, got %q", got)
+	}
+
+	flags := Flags{Bin: "go", EditorURL: "file://{path}"}
+	e := Event{Package: "github.com/some-programs/tgo/testdata/fail", Test: "TestFail"}
+	got := linkLocation(flags, e, "    fail_test.go:6: failed", fmt.Sprint)
+	want := "    " + hyperlink(flags.EditorURL, loc, "testdata/fail/fail_test.go:6:") + " failed"
+	if got != want {
+		t.Errorf("linkLocation() = %q, want %q", got, want)
+	}
+	if got := linkLocation(flags, e, "no location", fmt.Sprint); got != "no location" {
+		t.Errorf("unexpected %q", got)
+	}
+}
+
+func TestTestStorage_Quickfix(t *testing.T) {
+	ts := make(TestStorage)
+	pkg := "github.com/some-programs/tgo/testdata/fail"
+	gt := &GoTest{Dir: "/m"}
+	for _, e := range []Event{
+		{ImportPath: "b [b.test]", Action: ActionBuildOutput, Output: "# b [b.test]\n", GoTest: gt},
+		{ImportPath: "b [b.test]", Action: ActionBuildOutput, Output: "b/b_test.go:6:2: undefined: x\n", GoTest: gt},
+		{ImportPath: "b [b.test]", Action: ActionBuildFail, GoTest: gt},
+		{Package: pkg, Test: "TestFail", Action: ActionOutput, Output: "    fail_test.go:6: got 1\n"},
+		{Package: pkg, Test: "TestFail", Action: ActionFail},
+		{Package: pkg, Test: "TestPass", Action: ActionOutput, Output: "    fail_test.go:9: log\n"},
+		{Package: pkg, Test: "TestPass", Action: ActionPass},
+		{Package: pkg, Test: "TestPanic", Action: ActionOutput, Output: "panic: boom\n"},
+		{Package: pkg, Test: "TestPanic", Action: ActionOutput, Output: "\n"},
+		{Package: pkg, Test: "TestPanic", Action: ActionOutput, Output: "goroutine 8 [running]:\n"},
+		{Package: pkg, Test: "TestPanic", Action: ActionOutput, Output: "example.com/pkg.TestPanic(0x0)\n"},
+		{Package: pkg, Test: "TestPanic", Action: ActionOutput, Output: "\t/abs/panic_test.go:14 +0x29\n"},
+		{Package: pkg, Test: "TestPanic", Action: ActionPanic},
+	} {
+		ts.Append(e)
+	}
+
+	want := []string{
+		"/m/b/b_test.go:6:2: undefined: x",
+		"testdata/fail/fail_test.go:6:1: TestFail: got 1",
+		"/abs/panic_test.go:14:1: TestPanic: panic: boom",
+	}
+	if got := ts.Quickfix("go"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected quickfix:\n%s", strings.Join(got, "\n"))
+	}
+
+	path := filepath.Join(t.TempDir(), "quickfix")
+	if err := ts.WriteQuickfix("go", path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != strings.Join(want, "\n")+"\n" {
+		t.Errorf("unexpected file content %q", data)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	lines := []string{
+		"    a_test.go:10: mismatch (-want +got):",
+		"          strings.Join({",
+		"        - \t\"b\",",
+		"        + \t\"c\",",
+		"          }, \"\")",
+		"    a_test.go:12: other",
+		"        \tError:      \tNot equal:",
+		"        \t            \texpected: 1",
+		"        \t            \tactual  : 2",
+		"        \t            \t--- Expected",
+		"        \t            \t+++ Actual",
+		"        \t            \t@@ -1 +1 @@",
+		"        \t            \t-1",
+		"        \t            \t+2",
+		"--- FAIL: TestA (0.00s)",
+	}
+	kinds, hidden := diffLines(lines, true)
+	want := []diffKind{
+		diffHeader, diffContext, diffRemove, diffAdd, diffContext, diffNone,
+		diffNone, diffRemove, diffAdd, diffHeader, diffHeader, diffHunk, diffRemove, diffAdd, diffNone,
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Errorf("line %d %q: got kind %d, want %d", i, lines[i], kinds[i], want[i])
+		}
+		if hidden[i] {
+			t.Errorf("line %d: unexpectedly hidden", i)
+		}
+	}
+
+	long := []string{"diff (-want +got):"}
+	for i := range 100 {
+		if i == 50 {
+			long = append(long, "- \tx", "+ \ty")
+		}
+		long = append(long, "  \tsame")
+	}
+	_, hidden = diffLines(long, true)
+	var shown int
+	for _, h := range hidden {
+		if !h {
+			shown++
+		}
+	}
+	if shown != 1+2+2*diffContextLines {
+		t.Errorf("expected collapsed diff to show %d lines, got %d", 1+2+2*diffContextLines, shown)
+	}
+	if _, hidden = diffLines(long, false); slices.Contains(hidden, true) {
+		t.Error("expected no hidden lines without collapse")
+	}
+}
+
+var testifyOutput = []string{
+	"before",
+	"    a_test.go:17: ",
+	"        \tError Trace:\t/home/me/pkg/a_test.go:18",
+	"        \t            \t\t\t\t/home/me/pkg/helper_test.go:9",
+	"        \tError:      \tNot equal: ",
+	"        \t            \texpected: 1",
+	"        \t            \tactual  : 2",
+	"        \t            \t",
+	"        \t            \tDiff:",
+	"        \t            \t--- Expected",
+	"        \t            \t+++ Actual",
+	"        \t            \t@@ -1 +1 @@",
+	"        \t            \t-1",
+	"        \t            \t+2",
+	"        \tTest:       \tTestA",
+	"        \tMessages:   \tvalues differ",
+	"    a_test.go:19: after",
+}
+
+func TestParseTestify(t *testing.T) {
+	f, start, end, ok := ParseTestify(testifyOutput)
+	if !ok || start != 1 || end != 16 {
+		t.Fatalf("unexpected block %d-%d, %v", start, end, ok)
+	}
+	if len(f.Trace) != 2 || f.Trace[1] != "/home/me/pkg/helper_test.go:9" {
+		t.Errorf("unexpected trace: %q", f.Trace)
+	}
+	if strings.Join(f.Error, "|") != "Not equal:|expected: 1|actual  : 2" {
+		t.Errorf("unexpected error: %q", f.Error)
+	}
+	if strings.Join(f.Diff, "|") != "--- Expected|+++ Actual|@@ -1 +1 @@|-1|+2" {
+		t.Errorf("unexpected diff: %q", f.Diff)
+	}
+	if f.Test != "TestA" || len(f.Messages) != 1 || f.Messages[0] != "values differ" {
+		t.Errorf("unexpected test or messages: %q %q", f.Test, f.Messages)
+	}
+	loc, ok := f.Location()
+	if !ok || loc.File != "/home/me/pkg/a_test.go" || loc.Line != 18 || loc.Message != "Not equal: values differ" {
+		t.Errorf("unexpected location: %+v", loc)
+	}
+	if _, _, _, ok := ParseTestify([]string{"    a_test.go:3: plain"}); ok {
+		t.Error("expected no testify failure")
+	}
+
+	f, _, _, ok = ParseTestify([]string{
+		"        \tError Trace:\t/home/me/pkg/a_test.go:30",
+		"        \tError:      \tNot equal: ",
+		"        \t            \texpected: main.T{A:1, B:[]int{1, 2}}",
+		"        \t            \tactual  : main.T{A:1, B:[]int{1, 3}}",
+		"        \t            \t",
+		"        \t            \tDiff:",
+		"        \t            \t--- Expected",
+		"        \t            \t+++ Actual",
+		"        \t            \t@@ -1,6 +1,6 @@",
+		"        \t            \t (main.T) {",
+		"        \t            \t  A: (int) 1,",
+		"        \t            \t  B: ([]int) (len=2) {",
+		"        \t            \t   (int) 1,",
+		"        \t            \t-  (int) 2",
+		"        \t            \t+  (int) 3",
+		"        \t            \t  }",
+		"        \t            \t }",
+		"        \tTest:       \tTestB",
+	})
+	wantDiff := []string{
+		"--- Expected",
+		"+++ Actual",
+		"@@ -1,6 +1,6 @@",
+		" (main.T) {",
+		"  A: (int) 1,",
+		"  B: ([]int) (len=2) {",
+		"   (int) 1,",
+		"-  (int) 2",
+		"+  (int) 3",
+		"  }",
+		" }",
+	}
+	if !ok || strings.Join(f.Diff, "|") != strings.Join(wantDiff, "|") {
+		t.Errorf("unexpected struct diff: %q", f.Diff)
+	}
+}
+
+func TestEvents_CompactTestify(t *testing.T) {
+	var es Events
+	for _, line := range testifyOutput {
+		es = append(es, Event{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: line + "\n"})
+	}
+	compact := es.CompactTestify()
+	var got []string
+	for _, e := range compact {
+		got = append(got, strings.TrimSuffix(e.Output, "\n"))
+	}
+	want := []string{
+		"before",
+		"    /home/me/pkg/a_test.go:18: " + failColorBold("Not equal:"),
+		"        expected: 1",
+		"        actual  : 2",
+		"        --- Expected",
+		"        +++ Actual",
+		"        @@ -1 +1 @@",
+		"        -1",
+		"        +2",
+		"        messages: values differ",
+		"    a_test.go:19: after",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected compact output:\n%s", strings.Join(got, "\n"))
+	}
+
+	locs := es.FailureLocations("go")
+	if len(locs) != 1 || locs[0].Message != "Not equal: values differ" {
+		t.Errorf("unexpected failure locations: %+v", locs)
+	}
+}
+
+func TestEvents_OutputType(t *testing.T) {
+	es := Events{
+		{Package: "pkg", Test: "TestA", Action: ActionRun},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "=== RUN   TestA\n", OutputType: OutputTypeFrame},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "    a_test.go:5: some log\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "    a_test.go:6: broken\n", OutputType: OutputTypeError},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "        details\n", OutputType: OutputTypeErrorContinue},
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "--- FAIL: TestA (0.01s)\n", OutputType: OutputTypeFrame},
+		{Package: "pkg", Test: "TestA", Action: ActionFail, Elapsed: 0.02},
+	}
+	if !es.HasOutputTypes() || (Events{es[2]}).HasOutputTypes() {
+		t.Error("unexpected HasOutputTypes result")
+	}
+	if es[2].IsError() || !es[3].IsError() || !es[4].IsError() {
+		t.Error("unexpected IsError result")
+	}
+	for _, e := range es.Compact() {
+		if e.OutputType == OutputTypeFrame {
+			t.Errorf("expected frames to be compacted away, got %q", e.Output)
+		}
+	}
+
+	got := captureStdout(t, func() {
+		es.PrintDetail(Flags{V: V0, ErrorsOnly: true})
+	})
+	if strings.Contains(got, "some log") || !strings.Contains(got, "broken") || !strings.Contains(got, "details") {
+		t.Errorf("unexpected errors only output: %q", got)
+	}
+	got = captureStdout(t, func() {
+		es.PrintDetail(Flags{V: V0})
+	})
+	if !strings.Contains(got, "some log") {
+		t.Errorf("expected log output: %q", got)
+	}
+	if locs := es.FailureLocations("go"); len(locs) != 0 {
+		t.Errorf("expected no locations for an unknown package, got %v", locs)
+	}
+}
+
+func TestTestStorage_Attrs(t *testing.T) {
+	ts := make(TestStorage)
+	for _, line := range []string{
+		`{"Action":"run","Package":"pkg","Test":"TestA"}`,
+		`{"Action":"attr","Package":"pkg","Test":"TestA","Key":"owner","Value":"team-a"}`,
+		`{"Action":"attr","Package":"pkg","Test":"TestA","Key":"jira","Value":"X-1"}`,
+		`{"Action":"fail","Package":"pkg","Test":"TestA/sub"}`,
+		`{"Action":"fail","Package":"pkg","Test":"TestA"}`,
+		`{"Action":"attr","Package":"pkg","Test":"TestB","Key":"owner","Value":"team-b"}`,
+		`{"Action":"fail","Package":"pkg","Test":"TestB"}`,
+		`{"Action":"fail","Package":"pkg","Test":"TestC"}`,
+		`{"Action":"attr","Package":"pkg","Test":"TestD","Key":"owner","Value":""}`,
+		`{"Action":"fail","Package":"pkg","Test":"TestD"}`,
+	} {
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		ts.Append(e)
+	}
+
+	attrs := ts[Key{Package: "pkg", Test: "TestA"}].Attrs()
+	if attrs.String() != "owner=team-a jira=X-1" {
+		t.Errorf("unexpected attrs %q", attrs.String())
+	}
+	if v, ok := ts.Attr(Key{Package: "pkg", Test: "TestA/sub"}, "owner"); !ok || v != "team-a" {
+		t.Errorf("expected subtest to inherit owner, got %q, %v", v, ok)
+	}
+	if _, ok := ts.Attr(Key{Package: "pkg", Test: "TestC"}, "owner"); ok {
+		t.Error("expected no owner for TestC")
+	}
+
+	filtered := ts.FilterAttr(ts, "owner", "team-a")
+	if len(filtered) != 2 {
+		t.Errorf("expected TestA and its subtest, got %v", filtered.OrderedKeys())
+	}
+
+	groups := ts.GroupByAttr(ts, "owner")
+	if len(groups) != 4 || groups[0].Value != "" || groups[0].Missing || groups[0].Keys[0].Test != "TestD" ||
+		groups[1].Value != "team-a" || len(groups[1].Keys) != 2 || groups[2].Value != "team-b" ||
+		!groups[3].Missing || groups[3].Keys[0].Test != "TestC" {
+		t.Errorf("unexpected groups: %+v", groups)
+	}
+
+	var flags Flags
+	if err := flags.GroupBy.Set("attr:owner"); err != nil {
+		t.Fatal(err)
+	}
+	got := captureStdout(t, func() {
+		ts.printSummary(flags, ts, StatusFail)
+	})
+	if !strings.Contains(got, "owner=\n") || !strings.Contains(got, "no owner") {
+		t.Errorf("expected an empty owner apart from no owner: %q", got)
+	}
+	if err := flags.Attr.Set("owner=team-b"); err != nil {
+		t.Fatal(err)
+	}
+	got = captureStdout(t, func() {
+		ts.printSummary(flags, ts, StatusFail)
+	})
+	if !strings.Contains(got, "owner=team-b") || strings.Contains(got, "TestA") {
+		t.Errorf("unexpected grouped summary: %q", got)
+	}
+}
+
+func TestAttrFlags(t *testing.T) {
+	var af AttrFilter
+	if err := af.Set("owner=team-a"); err != nil || af.Name != "owner" || af.Value != "team-a" {
+		t.Errorf("unexpected filter %+v, %v", af, err)
+	}
+	if err := af.Set("owner="); err != nil || af.Name != "owner" || af.Value != "" {
+		t.Errorf("unexpected filter %+v, %v", af, err)
+	}
+	for _, v := range []string{"owner", "=team-a"} {
+		if err := af.Set(v); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+	var g GroupBy
+	if err := g.Set("attr:owner"); err != nil || g.Attr != "owner" || g.String() != "attr:owner" {
+		t.Errorf("unexpected grouping %+v, %v", g, err)
+	}
+	for _, v := range []string{"owner", "attr:"} {
+		if err := g.Set(v); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+}
+
+func TestTestStorage_JSONSummary(t *testing.T) {
+	ts := make(TestStorage)
+	ts.Append(Event{Package: "pkg", Test: "TestA", Action: ActionAttr, AttrKey: "owner", AttrValue: "me"})
+	ts.Append(Event{Package: "pkg", Test: "TestA", Action: ActionPass, Elapsed: 1.5})
+	ts.Append(Event{Package: "pkg", Test: "TestB", Action: ActionFail})
+	ts.Append(Event{Package: "pkg", Action: ActionFail, Elapsed: 2})
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	if err := ts.WriteJSONSummary(path, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s JSONSummary
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Counts[StatusPass] != 1 || s.Counts[StatusFail] != 1 || len(s.Results) != 3 {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+	if r := s.Results[0]; r.Test != "TestA" || r.Elapsed != 1.5 || r.Attrs["owner"] != "me" {
+		t.Errorf("unexpected first result: %+v", r)
+	}
+}
+
+func TestNormalizeError(t *testing.T) {
+	tests := []struct {
+		msg, want string
+	}{
+		{"dial tcp 127.0.0.1:43121: connection refused", "dial tcp 127.0.0.1:<port>: connection refused"},
+		{"dial tcp localhost:8080: refused", "dial tcp localhost:<port>: refused"},
+		{"took 1.5s, want < 200ms", "took <duration>, want < <duration>"},
+		{"object 0xc000123456 freed", "object <addr> freed"},
+		{"open /tmp/TestA123/001/x.txt: no such file", "open <tmp> no such file"},
+		{"got 42 items, want 7", "got <n> items, want <n>"},
+		{"TestA1 failed", "TestA1 failed"},
+		{"2024/01/02 15:04:05 got 3", "<time> got <n>"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeError(tt.msg); got != tt.want {
+			t.Errorf("NormalizeError(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestTestStorage_Clusters(t *testing.T) {
+	ts := make(TestStorage)
+	for _, e := range []Event{
+		{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "    a_test.go:5: dial tcp 127.0.0.1:4001: refused\n"},
+		{Package: "pkg", Test: "TestA", Action: ActionFail},
+		{Package: "pkg", Test: "TestB", Action: ActionOutput, Output: "    b_test.go:9: dial tcp 127.0.0.1:4002: refused\n"},
+		{Package: "pkg", Test: "TestB", Action: ActionFail},
+		{Package: "pkg", Test: "TestC/sub", Action: ActionOutput, Output: "    c_test.go:9: \n"},
+		{Package: "pkg", Test: "TestC/sub", Action: ActionOutput, Output: "        dial tcp 127.0.0.1:4003: refused\n"},
+		{Package: "pkg", Test: "TestC/sub", Action: ActionFail},
+		{Package: "pkg", Test: "TestC", Action: ActionFail},
+		{Package: "pkg", Test: "TestD", Action: ActionPanic, Output: "panic: boom\n→ pkg.helper  d_test.go:3\n"},
+		{Package: "pkg", Test: "TestE", Action: ActionTimeout},
+		{Package: "pkg", Test: "TestF", Action: ActionFail},
+		{Package: "pkg", Action: ActionFail},
+	} {
+		ts.Append(e)
+	}
+
+	if msg := ts[Key{Package: "pkg", Test: "TestD"}].FailureMessage(); msg != "panic: boom in pkg.helper" {
+		t.Errorf("unexpected panic message %q", msg)
+	}
+	clusters := ts.Clusters()
+	if len(clusters) != 3 {
+		t.Fatalf("expected 3 clusters, got %+v", clusters)
+	}
+	if c := clusters[0]; c.Signature != "dial tcp 127.0.0.1:<port>: refused" || len(c.Keys) != 3 || c.Keys[2].Test != "TestC/sub" {
+		t.Errorf("unexpected first cluster: %+v", c)
+	}
+	if clusters[2].Signature != "test timed out" {
+		t.Errorf("unexpected last cluster: %+v", clusters[2])
+	}
+
+	got := captureStdout(t, func() {
+		ts.PrintClusterSummary(Flags{})
+	})
+	if !strings.Contains(got, "3 tests:") || strings.Contains(got, "panic: boom") {
+		t.Errorf("unexpected cluster summary: %q", got)
+	}
+
+	ts.Append(Event{Package: "pkg", Test: "TestA", Action: ActionAttr, AttrKey: "owner", AttrValue: "team-a"})
+	ts.Append(Event{Package: "pkg", Test: "TestC", Action: ActionAttr, AttrKey: "owner", AttrValue: "team-a"})
+	got = captureStdout(t, func() {
+		ts.PrintClusterSummary(Flags{Attr: AttrFilter{Name: "owner", Value: "team-a"}})
+	})
+	if !strings.Contains(got, "2 tests:") || strings.Contains(got, "TestB") {
+		t.Errorf("unexpected filtered cluster summary: %q", got)
+	}
+}
+
+func TestNormalizeOutput(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"2024-01-02T15:04:05.123Z connected to 127.0.0.1:43121", "<time> connected to 127.0.0.1:<port>"},
+		{"2024/01/02 15:04:05 listening on [::1]:8080", "<time> listening on [::1]:<port>"},
+		{"took 1.5s at 12:01:02", "took <duration> at <time>"},
+		{"attempt 3 of 5", "attempt 3 of 5"},
+	}
+	for _, tt := range tests {
+		if got := normalizeOutput(tt.line); got != tt.want {
+			t.Errorf("normalizeOutput(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	a := []string{"start 127.0.0.1:1000", "retry", "done"}
+	b := []string{"start 127.0.0.1:2000", "retry", "retry", "retry", "failed"}
+	lines, kinds := lineDiff(a, b)
+	want := []string{"  start 127.0.0.1:2000", "  retry", "+ retry", "+ retry", "+ failed", "- done"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("unexpected diff: %q", lines)
+	}
+	if kinds[0] != diffContext || kinds[2] != diffAdd || kinds[5] != diffRemove {
+		t.Errorf("unexpected kinds: %v", kinds)
+	}
+}
+
+func TestHistory_LastPassDiff(t *testing.T) {
+	h, err := LoadHistory(filepath.Join(t.TempDir(), "history.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key{Package: "pkg", Test: "TestA"}
+	pass := make(TestStorage)
+	pass.Append(Event{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "=== RUN   TestA\n"})
+	pass.Append(Event{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "    a_test.go:5: attempt 1\n"})
+	pass.Append(Event{Time: time.Now(), Package: "pkg", Test: "TestA", Action: ActionPass})
+	pass.Append(Event{Time: time.Now(), Package: "pkg", Test: "TestB", Action: ActionPass})
+	h.Record(pass)
+	if lp := h.Tests[testHistoryKey(key)]; len(lp.Output) != 1 || lp.Output[0] != "    a_test.go:5: attempt 1" {
+		t.Errorf("unexpected recorded output: %+v", lp)
+	}
+	if _, ok := h.Tests["pkg TestB"]; ok {
+		t.Error("expected tests without output not to be recorded")
+	}
+
+	fail := make(TestStorage)
+	fail.Append(Event{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "    a_test.go:5: attempt 1\n"})
+	fail.Append(Event{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: "    a_test.go:5: attempt 2\n"})
+	fail.Append(Event{Package: "pkg", Test: "TestA", Action: ActionFail})
+	h.Record(fail)
+	if len(h.Tests[testHistoryKey(key)].Output) != 1 {
+		t.Error("expected failures to keep the last passing output")
+	}
+
+	got := captureStdout(t, func() {
+		h.PrintLastPassDiff(key, fail[key])
+	})
+	if !strings.Contains(got, "since last pass") || !strings.Contains(got, "+     a_test.go:5: attempt 2") {
+		t.Errorf("unexpected diff output: %q", got)
+	}
+	var none *History
+	none.PrintLastPassDiff(key, fail[key])
+
+	long := make(TestStorage)
+	for i := range maxHistoryOutput + 50 {
+		long.Append(Event{Package: "pkg", Test: "TestA", Action: ActionOutput, Output: fmt.Sprintf("    a_test.go:5: line %d\n", i)})
+	}
+	long.Append(Event{Package: "pkg", Test: "TestA", Action: ActionFail})
+	got = captureStdout(t, func() {
+		h.PrintLastPassDiff(key, long[key])
+	})
+	if !strings.Contains(got, "50 more lines not compared") {
+		t.Errorf("expected output over maxHistoryOutput lines not to be compared: %q", got)
+	}
+}
+
+func TestParseBenchLine(t *testing.T) {
+	r, ok := ParseBenchLine("BenchmarkJoin-8 \t     100\t       459.6 ns/op\t        42.00 widgets/op\t     112 B/op\t       1 allocs/op")
+	if !ok {
+		t.Fatal("benchmark line not parsed")
+	}
+	want := BenchResult{Name: "BenchmarkJoin-8", Iterations: 100, Values: []BenchValue{
+		{459.6, "ns/op"}, {42, "widgets/op"}, {112, "B/op"}, {1, "allocs/op"},
+	}}
+	if r.Name != want.Name || r.Iterations != want.Iterations || !slices.Equal(r.Values, want.Values) {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+	for _, line := range []string{"BenchmarkJoin", "BenchmarkJoin \t", "--- BENCH: BenchmarkJoin", "BenchmarkJoin 100 ns/op"} {
+		if _, ok := ParseBenchLine(line); ok {
+			t.Errorf("%q parsed as a benchmark result", line)
+		}
+	}
+}
+
+func TestTestStorage_Benchmarks(t *testing.T) {
+	t0 := time.Now()
+	ts := make(TestStorage)
+	ts.Append(Event{Time: t0, Package: "pkg", Action: ActionOutput, Output: "goos: linux\n"})
+	ts.Append(Event{Time: t0.Add(1), Package: "pkg", Test: "BenchmarkJoin", Action: ActionOutput, Output: "BenchmarkJoin \t"})
+	ts.Append(Event{Time: t0.Add(2), Package: "pkg", Test: "BenchmarkJoin", Action: ActionOutput, Output: "     100\t       300 ns/op\t     112 B/op\n"})
+	ts.Append(Event{Time: t0.Add(3), Package: "pkg", Action: ActionOutput, Output: "BenchmarkJoin \t     100\t       100 ns/op\t     112 B/op\n"})
+	ts.Append(Event{Time: t0.Add(4), Package: "pkg", Action: ActionOutput, Output: "BenchmarkJoin \t     100\t       200 ns/op\t     112 B/op\n"})
+	ts.Append(Event{Time: t0.Add(5), Package: "pkg", Action: ActionPass})
+
+	benchmarks := ts.Benchmarks()
+	if len(benchmarks) != 1 {
+		t.Fatalf("got %d benchmarks, want 1", len(benchmarks))
+	}
+	b := benchmarks[0]
+	if b.Name != "BenchmarkJoin" || b.Package != "pkg" || b.Samples != 3 {
+		t.Errorf("unexpected benchmark: %+v", b)
+	}
+	m, ok := b.Metric("ns/op")
+	if !ok || m.Median != 200 || m.Mean != 200 || m.StdDev != 100 {
+		t.Errorf("unexpected ns/op: %+v", m)
+	}
+	if got := m.Cell(); got != "200ns ± 50%" {
+		t.Errorf("got cell %q", got)
+	}
+	if m, _ := b.Metric("B/op"); m.Cell() != "112B ± 0%" {
+		t.Errorf("got cell %q", m.Cell())
+	}
+}
+
+func TestFormatBenchValue(t *testing.T) {
+	tests := []struct {
+		v    float64
+		unit string
+		want string
+	}{
+		{459.6, "ns/op", "460ns"},
+		{1234, "ns/op", "1.23µs"},
+		{2.5e6, "ns/op", "2.5ms"},
+		{3e9, "ns/op", "3s"},
+		{512, "B/op", "512B"},
+		{2048, "B/op", "2KiB"},
+		{3 << 20, "B/op", "3MiB"},
+		{7, "allocs/op", "7"},
+		{42.125, "widgets/op", "42.12"},
+	}
+	for _, tt := range tests {
+		if got := FormatBenchValue(tt.v, tt.unit); got != tt.want {
+			t.Errorf("FormatBenchValue(%v, %q) = %q, want %q", tt.v, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	tests := []struct {
+		x, y []float64
+		u, p float64
+	}{
+		{[]float64{1, 2, 3}, []float64{4, 5, 6}, 0, 0.1},
+		{[]float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, 25, 2.0 / 252},
+		{[]float64{1, 3, 5}, []float64{2, 4, 6}, 3, 0.7},
+		{[]float64{1, 1, 1}, []float64{1, 1, 1}, 4.5, 1},
+		{[]float64{1}, nil, 0, 1},
+	}
+	for _, tt := range tests {
+		u, p := MannWhitneyU(tt.x, tt.y)
+		if u != tt.u || math.Abs(p-tt.p) > 1e-9 {
+			t.Errorf("MannWhitneyU(%v, %v) = %v, %v, want %v, %v", tt.x, tt.y, u, p, tt.u, tt.p)
+		}
+	}
+
+	// ties use the normal approximation
+	_, p := MannWhitneyU([]float64{1, 1, 2, 2, 3, 3}, []float64{5, 5, 6, 6, 7, 7})
+	if p > 0.01 {
+		t.Errorf("got p=%v for separated samples with ties", p)
+	}
+}
+
+func TestMedianCI(t *testing.T) {
+	if _, _, ok := MedianCI([]float64{1, 2, 3, 4, 5}, 0.95); ok {
+		t.Error("got a 95% interval from 5 values")
+	}
+	lo, hi, ok := MedianCI([]float64{6, 1, 5, 2, 4, 3}, 0.95)
+	if !ok || lo != 1 || hi != 6 {
+		t.Errorf("got %v, %v, %v", lo, hi, ok)
+	}
+	lo, hi, _ = MedianCI([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0.95)
+	if lo != 2 || hi != 9 {
+		t.Errorf("got %v, %v", lo, hi)
+	}
+}
+
+func TestCompareBenchmarks(t *testing.T) {
+	bench := func(name, unit string, values ...float64) Benchmark {
+		b := Benchmark{Package: "pkg", Name: name, Samples: len(values)}
+		b.Metrics = []BenchMetric{{Unit: unit, Values: values}}
+		b.Metrics[0].stats()
+		return b
+	}
+	base := []Benchmark{
+		bench("BenchmarkA", "ns/op", 100, 101, 102, 103, 104),
+		bench("BenchmarkB", "MB/s", 100, 101, 102, 103, 104),
+		bench("BenchmarkC", "ns/op", 100, 101, 102, 103, 104),
+		bench("BenchmarkGone", "ns/op", 1),
+	}
+	cur := []Benchmark{
+		bench("BenchmarkA", "ns/op", 110, 111, 112, 113, 114),
+		bench("BenchmarkB", "MB/s", 110, 111, 112, 113, 114),
+		bench("BenchmarkC", "ns/op", 99, 102, 103, 101, 100),
+		bench("BenchmarkNew", "ns/op", 1),
+	}
+	cs := CompareBenchmarks(base, cur)
+	if len(cs) != 3 {
+		t.Fatalf("got %d comparisons, want 3", len(cs))
+	}
+	if c := cs[0]; math.Abs(c.Delta-100*10.0/102) > 1e-9 || !c.Significant() || !c.Regression(5) || c.Regression(10) {
+		t.Errorf("unexpected A: %+v", c)
+	}
+	if c := cs[1]; !c.Significant() || c.Regression(0) {
+		t.Errorf("higher MB/s is a regression: %+v", c)
+	}
+	if c := cs[2]; c.Significant() || c.Regression(0) {
+		t.Errorf("noise is significant: %+v", c)
+	}
+	if got := cs[2].deltaCell(); !strings.HasPrefix(got, "~ (p=") {
+		t.Errorf("got delta %q", got)
+	}
+}
+
+func TestBenchCompareArgs(t *testing.T) {
+	args, count, err := benchCompareArgs([]string{"./pkg"})
+	if err != nil || count != defaultBenchCount || strings.Join(args, " ") != "-bench=. -run=^$ -count=1 ./pkg" {
+		t.Errorf("got %q, %d, %v", args, count, err)
+	}
+	args, count, err = benchCompareArgs([]string{"-count", "10", "-bench", "Join", "-run=TestA", "-benchmem"})
+	if err != nil || count != 10 || strings.Join(args, " ") != "-bench Join -run=TestA -benchmem -count=1" {
+		t.Errorf("got %q, %d, %v", args, count, err)
+	}
+	if _, _, err := benchCompareArgs([]string{"-count=0"}); err == nil {
+		t.Error("no error for -count=0")
+	}
+}